@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mashiike/ichigeki/encryptlog"
+)
+
+func decryptCommand(_ context.Context, args []string) error {
+	cfg, err := defaultConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "ichigeki decrypt [options] [file ...]")
+		fs.PrintDefaults()
+	}
+	cfg.SetEncryptionFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.Restrict(); err != nil {
+		return err
+	}
+	if cfg.encryption == nil {
+		return errors.New("no key to decrypt: specify [encrypt] in the config, -encrypt-key-file or -encrypt-passphrase-env")
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		if err := decryptFile(os.Stdout, path, cfg.encryption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptFile decrypts the execution log file to w. The path `-` is stdin.
+func decryptFile(w io.Writer, path string, cfg *encryptlog.Config) error {
+	var rc io.ReadCloser = os.Stdin
+	if path != "-" {
+		fp, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		rc = fp
+	}
+	defer rc.Close()
+	r := encryptlog.NewReader(rc, cfg)
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if r.Truncated() {
+		log.Printf("[warn] %s: the execution log is truncated, the execution may have been killed", path)
+	}
+	return nil
+}
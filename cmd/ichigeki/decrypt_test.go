@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/encryptlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptFile(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	defer restore()
+	tempDir := t.TempDir()
+	keyFile := filepath.Join(tempDir, "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("QkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkI=\n"), 0600))
+	cfg := &config{
+		File:              &fileConfig{Dir: tempDir, Compression: "gzip"},
+		optEncryptKeyFile: keyFile,
+	}
+	require.NoError(t, cfg.Restrict())
+	ld, err := cfg.LogDestination(context.Background())
+	require.NoError(t, err)
+	h := &ichigeki.Hissatsu{
+		Name:           "migration",
+		LogDestination: ld,
+		ConfirmDialog:  ichigeki.Bool(false),
+		Script: func(_ ichigeki.Context, stdout io.Writer, _ io.Writer) error {
+			fmt.Fprint(stdout, "migrated")
+			return nil
+		},
+	}
+	require.NoError(t, h.Execute())
+
+	var shown bytes.Buffer
+	require.NoError(t, showExecution(context.Background(), &shown, ld, "migration", false))
+	require.Contains(t, shown.String(), "---\nmigrated\n---\n")
+
+	// the plaintext is compressed inside the encrypted log.
+	_, err = os.Stat(filepath.Join(tempDir, "migration.log.gz"))
+	require.True(t, os.IsNotExist(err))
+	var decrypted bytes.Buffer
+	require.NoError(t, decryptFile(&decrypted, filepath.Join(tempDir, "migration.log"), cfg.encryption))
+	require.EqualValues(t, shown.String(), decrypted.String())
+
+	err = decryptFile(&decrypted, filepath.Join(tempDir, "key"), cfg.encryption)
+	require.EqualError(t, err, filepath.Join(tempDir, "key")+": not an encrypted execution log")
+}
+
+func TestConfigLoadEncrypt(t *testing.T) {
+	cfg, err := loadConfig("testdata/encrypt.toml")
+	require.NoError(t, err)
+	require.EqualValues(t, &encryptConfig{PassphraseEnv: "ICHIGEKI_TEST_LOG_PASSPHRASE"}, cfg.Encrypt)
+
+	os.Unsetenv("ICHIGEKI_TEST_LOG_PASSPHRASE")
+	require.EqualError(t, cfg.Restrict(), "encrypt: environment variable ICHIGEKI_TEST_LOG_PASSPHRASE is empty")
+
+	os.Setenv("ICHIGEKI_TEST_LOG_PASSPHRASE", "correct horse battery staple")
+	defer os.Unsetenv("ICHIGEKI_TEST_LOG_PASSPHRASE")
+	require.NoError(t, cfg.Restrict())
+	require.Equal(t, "correct horse battery staple", cfg.encryption.Passphrase)
+	ld, err := cfg.LogDestination(context.Background())
+	require.NoError(t, err)
+	require.IsType(t, &encryptlog.LogDestination{}, ld)
+
+	cfg.optEncryptKeyFile = "testdata/not_found"
+	require.Error(t, cfg.Restrict())
+}
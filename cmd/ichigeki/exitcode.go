@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+
+	"github.com/mashiike/ichigeki"
+)
+
+// Exit codes of ichigeki. Except these, ichigeki exits with the exit code of the command, or 128+signal if the command is killed by a signal.
+const (
+	exitCodeError = 1
+	// exitCodeNotFound is the same as the shell, when the command is not found.
+	exitCodeNotFound = 127
+
+	// reserved for the refusals of ichigeki, so that the caller can tell them from a failure of the command.
+	exitCodeAlreadyExecuted      = 100
+	exitCodeNotInExecutionWindow = 101
+	exitCodeCanceled             = 102
+	exitCodeLogDestination       = 103
+	exitCodeHook                 = 104
+)
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	// before the exit code of the command, because a failed hook command has its own exit code.
+	if errors.Is(err, ichigeki.ErrHook) {
+		return exitCodeHook
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitCodeError
+	}
+	var interrupted *ichigeki.InterruptedError
+	if errors.As(err, &interrupted) {
+		if sig, ok := interrupted.Signal.(syscall.Signal); ok {
+			return 128 + int(sig)
+		}
+		return exitCodeError
+	}
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return exitCodeNotFound
+	case errors.Is(err, ichigeki.ErrAlreadyExecuted):
+		return exitCodeAlreadyExecuted
+	case errors.Is(err, ichigeki.ErrNotInExecutionWindow):
+		return exitCodeNotInExecutionWindow
+	case errors.Is(err, ichigeki.ErrCanceled), errors.Is(err, ichigeki.ErrNoTTY), errors.Is(err, ichigeki.ErrConfirmTimeout):
+		return exitCodeCanceled
+	case errors.Is(err, ichigeki.ErrLogDestination):
+		return exitCodeLogDestination
+	}
+	return exitCodeError
+}
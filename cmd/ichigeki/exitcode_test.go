@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "success", err: nil, expected: 0},
+		{name: "error", err: errors.New("hook BeforeRun: not ready"), expected: 1},
+		{name: "not_found", err: fmt.Errorf("command runtime error: %w", &exec.Error{Name: "not-found", Err: exec.ErrNotFound}), expected: 127},
+		{name: "interrupted", err: &ichigeki.InterruptedError{Signal: syscall.SIGTERM, Err: errors.New("context canceled")}, expected: 143},
+		{name: "already_executed", err: fmt.Errorf("wrapped: %w", ichigeki.ErrAlreadyExecuted), expected: 100},
+		{name: "not_in_execution_window", err: ichigeki.ErrNotInExecutionWindow, expected: 101},
+		{name: "canceled", err: ichigeki.ErrCanceled, expected: 102},
+		{name: "no_tty", err: fmt.Errorf("%w: open /dev/tty: no such device or address", ichigeki.ErrNoTTY), expected: 102},
+		{name: "log_destination", err: ichigeki.ErrLogDestination, expected: 103},
+		{name: "hook", err: fmt.Errorf("wrapped: %w", ichigeki.ErrHook), expected: 104},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.expected, exitCode(c.err))
+		})
+	}
+}
+
+func TestExitCodeOfCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands in this test require sh")
+	}
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	require.Equal(t, 3, exitCode(fmt.Errorf("command runtime error: %w", err)))
+
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	require.Equal(t, 143, exitCode(&ichigeki.InterruptedError{Signal: syscall.SIGTERM, Err: fmt.Errorf("command runtime error: %w", err)}))
+}
+
+func TestExitCodeOfHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands in this test require sh")
+	}
+	cfg := &config{BeforeCommand: "exit 3"}
+	h := &ichigeki.Hissatsu{
+		Name:     "test_run",
+		ExecDate: time.Now(),
+		LogDestination: &ichigeki.LocalFile{
+			Path: t.TempDir(),
+		},
+		Script: func(_ ichigeki.Context, _ io.Writer, _ io.Writer) error {
+			return nil
+		},
+		ConfirmDialog: ichigeki.Bool(false),
+		Hooks:         cfg.commandHooks(),
+	}
+	err := h.Execute()
+	require.ErrorIs(t, err, ichigeki.ErrHook)
+	require.Equal(t, 104, exitCode(err))
+}
+
+func TestExitCodeOfCanceledPrompt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	h := &ichigeki.Hissatsu{
+		Name:     "test_run",
+		ExecDate: time.Now(),
+		LogDestination: &ichigeki.LocalFile{
+			Path: t.TempDir(),
+		},
+		Script: func(_ ichigeki.Context, _ io.Writer, _ io.Writer) error {
+			return nil
+		},
+		PromptInput: pr,
+	}
+	err := h.ExecuteWithContext(ctx)
+	require.EqualError(t, err, "prompt canceled: context canceled")
+	require.ErrorIs(t, err, ichigeki.ErrCanceled)
+	require.Equal(t, 102, exitCode(err))
+	require.Equal(t, 130, exitCode(&ichigeki.InterruptedError{Signal: syscall.SIGINT, Err: err}))
+}
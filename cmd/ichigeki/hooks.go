@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/notify"
+)
+
+// Hooks returns the hooks running before_command, after_command and the notifiers.
+func (cfg *config) Hooks(ld ichigeki.LogDestination) ichigeki.Hooks {
+	return ichigeki.MergeHooks(cfg.commandHooks(), notify.Hooks(ld, cfg.notifiers...))
+}
+
+func (cfg *config) commandHooks() ichigeki.Hooks {
+	var hooks ichigeki.Hooks
+	if cfg.BeforeCommand != "" {
+		hooks.BeforeRun = func(ctx ichigeki.Context) error {
+			return runHookCommand(ctx, cfg.BeforeCommand, executionEnv(ctx))
+		}
+	}
+	if cfg.AfterCommand != "" {
+		hooks.AfterRun = func(ctx ichigeki.Context, scriptErr error, duration time.Duration) error {
+			env := executionEnv(ctx)
+			env = append(env, `ICHIGEKI_EXECUTION_DURATION=`+duration.String())
+			env = append(env, `ICHIGEKI_EXECUTION_STATUS=`+string(ctx.Outcome))
+			if scriptErr != nil {
+				env = append(env, `ICHIGEKI_EXECUTION_ERROR=`+scriptErr.Error())
+			}
+			// after_command runs even if the execution is interrupted, e.g. to notify it.
+			return runHookCommand(context.Background(), cfg.AfterCommand, env)
+		}
+	}
+	return hooks
+}
+
+// runHookCommand runs the command with the shell. The output goes to the console, not to the execution log.
+func runHookCommand(ctx context.Context, command string, env []string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s`: %w", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands in this test require sh")
+	}
+	tempDir := t.TempDir()
+	output := filepath.Join(tempDir, "hooks.txt")
+	cfg := &config{
+		BeforeCommand: `echo "before $ICHIGEKI_EXECUTION_NAME $ICHIGEKI_EXECUTION_RUN_ID" >> ` + output,
+		AfterCommand:  `echo "after $ICHIGEKI_EXECUTION_STATUS $ICHIGEKI_EXECUTION_ERROR" >> ` + output,
+	}
+	hooks := cfg.commandHooks()
+	ctx := ichigeki.Context{
+		Context:  context.Background(),
+		Name:     "test_run",
+		ExecDate: "2022-06-05",
+		RunID:    "00000000-0000-4000-8000-000000000000",
+	}
+	require.NoError(t, hooks.BeforeRun(ctx))
+	ctx.Outcome = ichigeki.OutcomeFailure
+	require.NoError(t, hooks.AfterRun(ctx, errors.New("boom"), time.Second))
+	bs, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "before test_run 00000000-0000-4000-8000-000000000000\nafter failure boom\n", string(bs))
+
+	cfg = &config{
+		BeforeCommand: "exit 3",
+	}
+	require.Error(t, cfg.commandHooks().BeforeRun(ctx))
+	require.Nil(t, cfg.commandHooks().AfterRun)
+}
+
+func TestConfigHooksTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands in this test require sh")
+	}
+	tempDir := t.TempDir()
+	output := filepath.Join(tempDir, "hooks.txt")
+	cfg := &config{
+		AfterCommand: `echo "after $ICHIGEKI_EXECUTION_STATUS" >> ` + output,
+	}
+	h := &ichigeki.Hissatsu{
+		Name:     "test_run",
+		ExecDate: time.Now(),
+		LogDestination: &ichigeki.LocalFile{
+			Path: tempDir,
+		},
+		Timeout: 10 * time.Millisecond,
+		Script: func(ctx ichigeki.Context, _ io.Writer, _ io.Writer) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		ConfirmDialog: ichigeki.Bool(false),
+		Hooks:         cfg.commandHooks(),
+	}
+	require.ErrorIs(t, h.Execute(), context.DeadlineExceeded)
+	bs, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "after timeout\n", string(bs))
+}
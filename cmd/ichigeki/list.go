@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+func listCommand(ctx context.Context, args []string) error {
+	cfg, err := defaultConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "ichigeki list [options]")
+		fs.PrintDefaults()
+	}
+	cfg.SetDestinationFlags(fs)
+	var since, namePrefix, output string
+	fs.StringVar(&since, "since", "", "list executions started since the time (e.g. 24h, 2022-06-01T00:00)")
+	fs.StringVar(&namePrefix, "name-prefix", "", "list executions whose name starts with the prefix")
+	fs.StringVar(&output, "output", "table", "output format (table, json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.Restrict(); err != nil {
+		return err
+	}
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format `%s`", output)
+	}
+	var sinceTime time.Time
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			sinceTime = flextime.Now().Add(-d)
+		} else if sinceTime, err = parseDateTime(since, cfg.location); err != nil {
+			return fmt.Errorf("since parse failed: %w", err)
+		}
+	}
+	ld, err := cfg.LogDestination(ctx)
+	if err != nil {
+		return err
+	}
+	items, err := listExecutions(ctx, ld, namePrefix, sinceTime)
+	if err != nil {
+		return err
+	}
+	return writeExecutions(os.Stdout, items, output, cfg.location)
+}
+
+type execution struct {
+	// Name is the name in the log destination, which differs from the name in the header for archived logs.
+	Name string `json:"name"`
+	*logparse.Log
+}
+
+func listExecutions(ctx context.Context, ld ichigeki.LogDestination, namePrefix string, since time.Time) ([]execution, error) {
+	lister, ok := ld.(ichigeki.Lister)
+	if !ok {
+		return nil, fmt.Errorf("log destination [%s] can not be listed", ld.String())
+	}
+	_, isMetadataReader := ld.(ichigeki.MetadataReader)
+	_, isReader := ld.(ichigeki.Reader)
+	if !isMetadataReader && !isReader {
+		return nil, fmt.Errorf("log destination [%s] can not be read", ld.String())
+	}
+	entries, err := lister.List(ctx, namePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list log destination [%s]: %w", ld.String(), err)
+	}
+	items := make([]execution, 0, len(entries))
+	for _, entry := range entries {
+		if !since.IsZero() && entry.LastModified.Before(since) {
+			continue
+		}
+		ld.SetName(entry.Name)
+		// only the header and the footer are read, so that the whole logs are not downloaded from S3.
+		l, err := ichigeki.ReadMetadata(ctx, ld)
+		if err != nil {
+			if errors.Is(err, logparse.ErrNotExecutionLog) {
+				continue
+			}
+			log.Printf("[warn] %s: %s", entry.Name, err)
+			continue
+		}
+		if !since.IsZero() && !l.Start.IsZero() && l.Start.Before(since) {
+			continue
+		}
+		items = append(items, execution{Name: entry.Name, Log: l})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Start.Before(items[j].Start)
+	})
+	return items, nil
+}
+
+func writeExecutions(w io.Writer, items []execution, output string, loc *time.Location) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTART\tEND\tOUTCOME")
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.In(loc).Format(time.RFC3339)
+	}
+	for _, item := range items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", item.Name, formatTime(item.Start), formatTime(item.End), item.Outcome)
+	}
+	return tw.Flush()
+}
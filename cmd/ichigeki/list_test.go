@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListExecutions(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	tempDir := t.TempDir()
+	execute := func(name string, now time.Time, scriptErr error) {
+		restore := flextime.Fix(now)
+		defer restore()
+		h := &ichigeki.Hissatsu{
+			Name:     name,
+			Location: jst,
+			LogDestination: &ichigeki.LocalFile{
+				Path: tempDir,
+			},
+			ConfirmDialog: ichigeki.Bool(false),
+			Script: func(_ ichigeki.Context, _ io.Writer, _ io.Writer) error {
+				return scriptErr
+			},
+		}
+		h.Execute()
+	}
+	execute("migration-b", time.Date(2022, 6, 2, 10, 0, 0, 0, jst), errors.New("oops"))
+	execute("migration-a", time.Date(2022, 6, 1, 10, 0, 0, 0, jst), nil)
+	execute("backfill", time.Date(2022, 6, 3, 10, 0, 0, 0, jst), nil)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "other.log"), []byte("not ichigeki\n"), 0644))
+
+	ld := &ichigeki.LocalFile{Path: tempDir}
+	items, err := listExecutions(context.Background(), ld, "migration", time.Time{})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, writeExecutions(&buf, items, "table", jst))
+	expected := `NAME         START                      END                        OUTCOME
+migration-a  2022-06-01T10:00:00+09:00  2022-06-01T10:00:00+09:00  success
+migration-b  2022-06-02T10:00:00+09:00  2022-06-02T10:00:00+09:00  failure
+`
+	require.EqualValues(t, expected, buf.String())
+
+	items, err = listExecutions(context.Background(), ld, "", time.Date(2022, 6, 2, 0, 0, 0, 0, jst))
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.EqualValues(t, "migration-b", items[0].Name)
+	require.EqualValues(t, "backfill", items[1].Name)
+}
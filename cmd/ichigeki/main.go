@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,11 +11,15 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
+	"regexp"
 	"time"
+	_ "time/tzdata"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/encryptlog"
+	"github.com/mashiike/ichigeki/notify"
 	"github.com/mashiike/ichigeki/s3log"
 	"github.com/pelletier/go-toml"
 )
@@ -21,11 +27,36 @@ import (
 const (
 	Version           = "current"
 	defaultConfigPath = ".config/ichigeki/default.toml"
+	// defaultGracePeriod is shorter than the stop timeout of ECS (30s), so that the footer is written before SIGKILL.
+	defaultGracePeriod = 10 * time.Second
 )
 
+// subcommands are dispatched by the first argument. To execute a command of the same name, use `ichigeki -- (commands)`.
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"list":    listCommand,
+	"show":    showCommand,
+	"decrypt": decryptCommand,
+	"verify":  verifyCommand,
+}
+
 func main() {
+	ichigeki.Version = Version
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			ctx, _, stop := notifySignals(context.Background())
+			defer stop()
+			if err := subcommand(ctx, os.Args[2:]); err != nil {
+				log.Fatal("[error] ", err)
+			}
+			return
+		}
+	}
 	flag.CommandLine.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "ichigeki [options] -- (commands)")
+		fmt.Fprintln(flag.CommandLine.Output(), "ichigeki list [options]")
+		fmt.Fprintln(flag.CommandLine.Output(), "ichigeki show [options] <name>")
+		fmt.Fprintln(flag.CommandLine.Output(), "ichigeki decrypt [options] [file ...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "ichigeki verify [options] <name>")
 		fmt.Fprintln(flag.CommandLine.Output(), "version:", Version)
 		flag.CommandLine.PrintDefaults()
 	}
@@ -50,7 +81,7 @@ func main() {
 		log.Fatal("[error] commands not found")
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, received, stop := notifySignals(context.Background())
 	defer stop()
 	ld, err := cfg.LogDestination(ctx)
 	if err != nil {
@@ -63,51 +94,194 @@ func main() {
 		LogDestination:      ld,
 		ConfirmDialog:       cfg.ConfirmDialog,
 		ExecDate:            cfg.ExecDate,
+		NotBefore:           cfg.notBefore,
+		NotAfter:            cfg.notAfter,
+		Location:            cfg.location,
+		RerunPolicy:         cfg.rerunPolicy,
+		RerunUnfinished:     cfg.optRerunUnfinished,
+		DryRun:              cfg.optDryRun,
+		ConfirmMode:         cfg.confirmMode,
+		ConfirmPhrase:       cfg.ConfirmPhrase,
+		Confirmer:           cfg.confirmer,
+		ConfirmTimeout:      cfg.confirmTimeout,
+		Hooks:               cfg.Hooks(ld),
+		Timeout:             cfg.timeout,
+		LogFormat:           cfg.logFormat,
+		Redactor:            cfg.redactor,
+		DigestKey:           cfg.digestKey,
 		Script: func(ctx ichigeki.Context, stdout io.Writer, stderr io.Writer) error {
-			env := os.Environ()
-			env = append(env, `ICHIGEKI_EXECUTION_ENV=ichigeki `+Version)
-			env = append(env, `ICHIGEKI_EXECUTION_NAME=`+ctx.Name)
-			env = append(env, `ICHIGEKI_EXECUTION_DATE=`+ctx.ExecDate)
-			cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+			cmd := exec.Command(args[0], args[1:]...)
 			cmd.Stdin = os.Stdin
 			cmd.Stdout = stdout
 			cmd.Stderr = stderr
-			cmd.Env = env
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("command runtime error: %w", err)
+			cmd.Env = executionEnv(ctx)
+			if err := runCommand(ctx, cmd, cfg.gracePeriod); err != nil {
+				err = fmt.Errorf("command runtime error: %w", err)
+				if sig := received(); sig != nil {
+					return &ichigeki.InterruptedError{Signal: sig, Err: err}
+				}
+				return err
 			}
 			return nil
 		},
 	}
 
 	if err := h.ExecuteWithContext(ctx); err != nil {
-		log.Fatal("[error] ", err)
+		// e.g. the confirm dialog is canceled by the signal.
+		var interrupted *ichigeki.InterruptedError
+		if sig := received(); sig != nil && !errors.As(err, &interrupted) {
+			err = &ichigeki.InterruptedError{Signal: sig, Err: err}
+		}
+		log.Println("[error]", err)
+		stop()
+		os.Exit(exitCode(err))
+	}
+}
+
+// executionEnv returns the environment variables for the command and the hook commands.
+func executionEnv(ctx ichigeki.Context) []string {
+	env := os.Environ()
+	env = append(env, `ICHIGEKI_EXECUTION_ENV=ichigeki `+Version)
+	env = append(env, `ICHIGEKI_EXECUTION_NAME=`+ctx.Name)
+	env = append(env, `ICHIGEKI_EXECUTION_DATE=`+ctx.ExecDate)
+	env = append(env, `ICHIGEKI_EXECUTION_RUN_ID=`+ctx.RunID)
+	if ctx.DryRun {
+		env = append(env, `ICHIGEKI_DRY_RUN=1`)
 	}
+	return env
 }
 
 type config struct {
-	Name                string      `toml:"-"`
-	ConfirmDialog       *bool       `toml:"confirm_dialog"`
-	DefaultNameTemplate string      `toml:"default_name_template"`
-	File                *fileConfig `toml:"file"`
-	S3                  *s3Config   `toml:"s3"`
-	ExecDate            time.Time   `toml:"-"`
+	Name                string         `toml:"-"`
+	ConfirmDialog       *bool          `toml:"confirm_dialog"`
+	DefaultNameTemplate string         `toml:"default_name_template"`
+	File                *fileConfig    `toml:"file"`
+	S3                  *s3Config      `toml:"s3"`
+	RerunPolicy         string         `toml:"rerun_policy"`
+	NotBefore           string         `toml:"not_before"`
+	NotAfter            string         `toml:"not_after"`
+	Timezone            string         `toml:"timezone"`
+	ConfirmMode         string         `toml:"confirm_mode"`
+	ConfirmPhrase       string         `toml:"confirm_phrase"`
+	Confirmer           string         `toml:"confirmer"`
+	ConfirmTokenEnv     string         `toml:"confirm_token_env"`
+	ConfirmToken        string         `toml:"confirm_token"`
+	ApprovalFile        string         `toml:"approval_file"`
+	ConfirmTimeout      string         `toml:"confirm_timeout"`
+	BeforeCommand       string         `toml:"before_command"`
+	AfterCommand        string         `toml:"after_command"`
+	Notify              *notifyConfig  `toml:"notify"`
+	GracePeriod         string         `toml:"grace_period"`
+	Timeout             string         `toml:"timeout"`
+	LogFormat           string         `toml:"log_format"`
+	Redact              *redactConfig  `toml:"redact"`
+	Encrypt             *encryptConfig `toml:"encrypt"`
+	Digest              *digestConfig  `toml:"digest"`
+	ExecDate            time.Time      `toml:"-"`
 
 	optDir             string `toml:"-"`
 	optName            string `toml:"-"`
 	optS3URLPrefix     string `toml:"-"`
 	optNoConfirmDialog bool   `toml:"-"`
 	optExecDate        string `toml:"-"`
+	optRerunPolicy     string `toml:"-"`
+	optRerunUnfinished bool   `toml:"-"`
+	optNotBefore       string `toml:"-"`
+	optNotAfter        string `toml:"-"`
+	optTimezone        string `toml:"-"`
+	optDryRun          bool   `toml:"-"`
+	optConfirmMode     string `toml:"-"`
+	optConfirmPhrase   string `toml:"-"`
+	optConfirmer       string `toml:"-"`
+	optApprovalFile    string `toml:"-"`
+	optConfirmTimeout  string `toml:"-"`
+	optGracePeriod     string `toml:"-"`
+	optTimeout         string `toml:"-"`
+	optLogFormat       string `toml:"-"`
+
+	optEncryptKeyFile       string `toml:"-"`
+	optEncryptPassphraseEnv string `toml:"-"`
+	optDigestKeyFile        string `toml:"-"`
+	optDigestKeyEnv         string `toml:"-"`
+
+	rerunPolicy ichigeki.RerunPolicy
+	notBefore   time.Time
+	notAfter    time.Time
+	location    *time.Location
+	confirmMode ichigeki.ConfirmMode
+	confirmer   ichigeki.Confirmer
+
+	confirmTimeout time.Duration
+	notifiers      []notify.Notifier
+	gracePeriod    time.Duration
+	timeout        time.Duration
+	logFormat      ichigeki.LogFormat
+	redactor       *ichigeki.Redactor
+	encryption     *encryptlog.Config
+	digestKey      []byte
+}
+
+type notifyConfig struct {
+	Webhook []*webhookConfig `toml:"webhook"`
+}
+
+type webhookConfig struct {
+	URL      string            `toml:"url"`
+	Template string            `toml:"template"`
+	Events   []string          `toml:"events"`
+	Headers  map[string]string `toml:"headers"`
+}
+
+type redactConfig struct {
+	Env      []string `toml:"env"`
+	Patterns []string `toml:"patterns"`
+	Mask     string   `toml:"mask"`
+}
+
+type encryptConfig struct {
+	KeyFile       string `toml:"key_file"`
+	PassphraseEnv string `toml:"passphrase_env"`
+}
+
+type digestConfig struct {
+	KeyFile string `toml:"key_file"`
+	KeyEnv  string `toml:"key_env"`
 }
 
 type s3Config struct {
-	Bucket       string `toml:"bucket"`
-	ObjectPrefix string `toml:"object_prefix"`
+	Bucket               string            `toml:"bucket"`
+	ObjectPrefix         string            `toml:"object_prefix"`
+	Compression          string            `toml:"compression"`
+	ServerSideEncryption string            `toml:"server_side_encryption"`
+	SSEKMSKeyID          string            `toml:"sse_kms_key_id"`
+	StorageClass         string            `toml:"storage_class"`
+	Tags                 map[string]string `toml:"tags"`
+	Metadata             map[string]string `toml:"metadata"`
+	ExpectedBucketOwner  string            `toml:"expected_bucket_owner"`
+
+	compression ichigeki.Compression
+}
+
+func (cfg *s3Config) logConfig() *s3log.Config {
+	return &s3log.Config{
+		Bucket:               cfg.Bucket,
+		ObjectPrefix:         cfg.ObjectPrefix,
+		Compression:          cfg.compression,
+		ServerSideEncryption: types.ServerSideEncryption(cfg.ServerSideEncryption),
+		SSEKMSKeyID:          cfg.SSEKMSKeyID,
+		StorageClass:         types.StorageClass(cfg.StorageClass),
+		Tags:                 cfg.Tags,
+		Metadata:             cfg.Metadata,
+		ExpectedBucketOwner:  cfg.ExpectedBucketOwner,
+	}
 }
 
 type fileConfig struct {
 	Dir            string `toml:"dir"`
 	LogFilePostfix string `toml:"log_file_postfix"`
+	Compression    string `toml:"compression"`
+
+	compression ichigeki.Compression
 }
 
 func loadConfig(path string) (*config, error) {
@@ -146,11 +320,44 @@ func defaultConfig() (*config, error) {
 }
 
 func (cfg *config) SetFlags(fs *flag.FlagSet) {
-	flag.StringVar(&cfg.optDir, "dir", "", "log destination for s3")
-	flag.StringVar(&cfg.optName, "name", "", "ichigeki name")
-	flag.StringVar(&cfg.optS3URLPrefix, "s3-url-prefix", "", "log destination for s3")
-	flag.StringVar(&cfg.optExecDate, "exec-date", "", "scheduled execution date")
-	flag.BoolVar(&cfg.optNoConfirmDialog, "no-confirm-dialog", false, "do confirm")
+	cfg.SetDestinationFlags(fs)
+	cfg.SetDigestFlags(fs)
+	fs.StringVar(&cfg.optName, "name", "", "ichigeki name")
+	fs.StringVar(&cfg.optExecDate, "exec-date", "", "scheduled execution date")
+	fs.BoolVar(&cfg.optNoConfirmDialog, "no-confirm-dialog", false, "do confirm")
+	fs.StringVar(&cfg.optConfirmMode, "confirm-mode", "", "how to confirm in the confirm dialog (yes-no, type-name, type-phrase)")
+	fs.StringVar(&cfg.optConfirmPhrase, "confirm-phrase", "", "phrase to type for confirm mode type-phrase")
+	fs.StringVar(&cfg.optConfirmer, "confirmer", "", "how to approve the execution (prompt, env-token, approval-file)")
+	fs.StringVar(&cfg.optApprovalFile, "approval-file", "", "approval file for confirmer approval-file")
+	fs.StringVar(&cfg.optConfirmTimeout, "confirm-timeout", "", "abort the confirm dialog if nobody answers within the duration (e.g. 5m)")
+	fs.StringVar(&cfg.optNotBefore, "not-before", "", "start of execution window (e.g. 2022-06-01T22:00)")
+	fs.StringVar(&cfg.optNotAfter, "not-after", "", "end of execution window (e.g. 2022-06-02T05:00, a date only means the end of the day)")
+	fs.BoolVar(&cfg.optDryRun, "dry-run", false, "rehearse the execution without consuming the name. log output to name.dryrun.log")
+	fs.StringVar(&cfg.optLogFormat, "log-format", "", "format of the execution log body (raw, timestamped)")
+	fs.StringVar(&cfg.optTimeout, "timeout", "", "terminate the command if it runs longer than the duration (e.g. 30m)")
+	fs.StringVar(&cfg.optGracePeriod, "grace-period", "", "wait for the command to exit after SIGTERM before SIGKILL (default: 10s)")
+	fs.StringVar(&cfg.optRerunPolicy, "rerun-policy", "", "rerun policy when execution log already exists (never, allow-after-failure, allow-after-interrupted)")
+	fs.BoolVar(&cfg.optRerunUnfinished, "rerun-unfinished", false, "treat the execution log without the footer as interrupted. use it only when the previous execution is not running")
+}
+
+// SetDestinationFlags sets the flags shared with subcommands.
+func (cfg *config) SetDestinationFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.optDir, "dir", "", "log destination for s3")
+	fs.StringVar(&cfg.optS3URLPrefix, "s3-url-prefix", "", "log destination for s3")
+	fs.StringVar(&cfg.optTimezone, "tz", "", "time zone for execution date (e.g. Asia/Tokyo, default: local)")
+	cfg.SetEncryptionFlags(fs)
+}
+
+// SetDigestFlags sets the flags of the HMAC key of the digest in the footer.
+func (cfg *config) SetDigestFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.optDigestKeyFile, "digest-key-file", "", "record the digest of the execution log as HMAC-SHA256 with the key in the file")
+	fs.StringVar(&cfg.optDigestKeyEnv, "digest-key-env", "", "record the digest of the execution log as HMAC-SHA256 with the key in the environment variable")
+}
+
+// SetEncryptionFlags sets the flags of the key to encrypt and decrypt the execution log.
+func (cfg *config) SetEncryptionFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.optEncryptKeyFile, "encrypt-key-file", "", "encrypt the execution log with the 256-bit key in the file (hex or base64)")
+	fs.StringVar(&cfg.optEncryptPassphraseEnv, "encrypt-passphrase-env", "", "encrypt the execution log with the passphrase in the environment variable")
 }
 
 func (cfg *config) Restrict() error {
@@ -181,53 +388,333 @@ func (cfg *config) Restrict() error {
 			if err != nil {
 				return fmt.Errorf("can not convert to abs path: %w", err)
 			}
-			if cfg.File == nil {
-				cfg.File = &fileConfig{}
-			}
-			cfg.File.Dir = cfg.optDir
 		}
+		if cfg.File == nil {
+			cfg.File = &fileConfig{}
+		}
+		cfg.File.Dir = cfg.optDir
+	}
+
+	if cfg.optTimezone != "" {
+		cfg.Timezone = cfg.optTimezone
+	}
+	cfg.location = time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("timezone load failed: %w", err)
+		}
+		cfg.location = loc
 	}
 
 	if cfg.optExecDate != "" {
-		t, err := time.Parse("2006-01-02", cfg.optExecDate)
+		t, err := time.ParseInLocation("2006-01-02", cfg.optExecDate, cfg.location)
 		if err != nil {
 			return fmt.Errorf("exec date parse failed: %w", err)
 		}
 		cfg.ExecDate = t
 	}
+
+	if cfg.optRerunPolicy != "" {
+		cfg.RerunPolicy = cfg.optRerunPolicy
+	}
+	p, err := ichigeki.ParseRerunPolicy(cfg.RerunPolicy)
+	if err != nil {
+		return fmt.Errorf("rerun policy: %w", err)
+	}
+	cfg.rerunPolicy = p
+
+	if cfg.optConfirmMode != "" {
+		cfg.ConfirmMode = cfg.optConfirmMode
+	}
+	if cfg.confirmMode, err = ichigeki.ParseConfirmMode(cfg.ConfirmMode); err != nil {
+		return fmt.Errorf("confirm mode: %w", err)
+	}
+	if cfg.optConfirmPhrase != "" {
+		cfg.ConfirmPhrase = cfg.optConfirmPhrase
+	}
+	if cfg.optConfirmTimeout != "" {
+		cfg.ConfirmTimeout = cfg.optConfirmTimeout
+	}
+	if cfg.ConfirmTimeout != "" {
+		if cfg.confirmTimeout, err = time.ParseDuration(cfg.ConfirmTimeout); err != nil {
+			return fmt.Errorf("confirm timeout parse failed: %w", err)
+		}
+	}
+	if cfg.optConfirmer != "" {
+		cfg.Confirmer = cfg.optConfirmer
+	}
+	if cfg.optApprovalFile != "" {
+		cfg.ApprovalFile = cfg.optApprovalFile
+	}
+	switch cfg.Confirmer {
+	case "", "prompt":
+		// default confirm dialog
+	case "env-token":
+		if cfg.ConfirmTokenEnv == "" {
+			cfg.ConfirmTokenEnv = "ICHIGEKI_CONFIRM_TOKEN"
+		}
+		cfg.confirmer = &ichigeki.EnvTokenConfirmer{
+			EnvName: cfg.ConfirmTokenEnv,
+			Token:   cfg.ConfirmToken,
+		}
+	case "approval-file":
+		if cfg.ApprovalFile == "" {
+			return errors.New("approval_file is required for confirmer approval-file")
+		}
+		cfg.confirmer = &ichigeki.ApprovalFileConfirmer{
+			Path: cfg.ApprovalFile,
+		}
+	default:
+		return fmt.Errorf("unknown confirmer `%s`", cfg.Confirmer)
+	}
+
+	if cfg.optNotBefore != "" {
+		cfg.NotBefore = cfg.optNotBefore
+	}
+	if cfg.NotBefore != "" {
+		if cfg.notBefore, err = parseDateTime(cfg.NotBefore, cfg.location); err != nil {
+			return fmt.Errorf("not before parse failed: %w", err)
+		}
+	}
+	if cfg.optNotAfter != "" {
+		cfg.NotAfter = cfg.optNotAfter
+	}
+	if cfg.NotAfter != "" {
+		if cfg.notAfter, err = parseNotAfter(cfg.NotAfter, cfg.location); err != nil {
+			return fmt.Errorf("not after parse failed: %w", err)
+		}
+	}
+
+	if cfg.optGracePeriod != "" {
+		cfg.GracePeriod = cfg.optGracePeriod
+	}
+	cfg.gracePeriod = defaultGracePeriod
+	if cfg.GracePeriod != "" {
+		if cfg.gracePeriod, err = time.ParseDuration(cfg.GracePeriod); err != nil {
+			return fmt.Errorf("grace period parse failed: %w", err)
+		}
+	}
+
+	if cfg.optTimeout != "" {
+		cfg.Timeout = cfg.optTimeout
+	}
+	if cfg.Timeout != "" {
+		if cfg.timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return fmt.Errorf("timeout parse failed: %w", err)
+		}
+	}
+
+	if cfg.optLogFormat != "" {
+		cfg.LogFormat = cfg.optLogFormat
+	}
+	if cfg.logFormat, err = ichigeki.ParseLogFormat(cfg.LogFormat); err != nil {
+		return fmt.Errorf("log format: %w", err)
+	}
+
+	if cfg.S3 != nil {
+		if cfg.S3.compression, err = ichigeki.ParseCompression(cfg.S3.Compression); err != nil {
+			return fmt.Errorf("s3 compression: %w", err)
+		}
+		if cfg.S3.Bucket != "" {
+			if err := cfg.S3.logConfig().Validate(); err != nil {
+				return fmt.Errorf("s3: %w", err)
+			}
+		}
+	}
+	if cfg.File != nil {
+		if cfg.File.compression, err = ichigeki.ParseCompression(cfg.File.Compression); err != nil {
+			return fmt.Errorf("file compression: %w", err)
+		}
+	}
+
+	cfg.redactor = nil
+	if cfg.Redact != nil {
+		cfg.redactor = &ichigeki.Redactor{
+			Env:  cfg.Redact.Env,
+			Mask: cfg.Redact.Mask,
+		}
+		for _, pattern := range cfg.Redact.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("redact pattern: %w", err)
+			}
+			cfg.redactor.Patterns = append(cfg.redactor.Patterns, re)
+		}
+	}
+
+	if cfg.optEncryptKeyFile != "" || cfg.optEncryptPassphraseEnv != "" {
+		cfg.Encrypt = &encryptConfig{
+			KeyFile:       cfg.optEncryptKeyFile,
+			PassphraseEnv: cfg.optEncryptPassphraseEnv,
+		}
+	}
+	cfg.encryption = nil
+	if cfg.Encrypt != nil {
+		cfg.encryption = &encryptlog.Config{}
+		if cfg.Encrypt.KeyFile != "" {
+			if cfg.encryption.Key, err = encryptlog.LoadKeyFile(cfg.Encrypt.KeyFile); err != nil {
+				return fmt.Errorf("encrypt: %w", err)
+			}
+		}
+		if cfg.Encrypt.PassphraseEnv != "" {
+			cfg.encryption.Passphrase = os.Getenv(cfg.Encrypt.PassphraseEnv)
+			if cfg.encryption.Passphrase == "" {
+				return fmt.Errorf("encrypt: environment variable %s is empty", cfg.Encrypt.PassphraseEnv)
+			}
+		}
+		if err := cfg.encryption.Validate(); err != nil {
+			return fmt.Errorf("encrypt: %w", err)
+		}
+	}
+
+	if cfg.optDigestKeyFile != "" || cfg.optDigestKeyEnv != "" {
+		cfg.Digest = &digestConfig{
+			KeyFile: cfg.optDigestKeyFile,
+			KeyEnv:  cfg.optDigestKeyEnv,
+		}
+	}
+	cfg.digestKey = nil
+	if cfg.Digest != nil {
+		if cfg.Digest.KeyFile != "" && cfg.Digest.KeyEnv != "" {
+			return errors.New("digest: key_file and key_env are exclusive")
+		}
+		if cfg.Digest.KeyFile != "" {
+			bs, err := os.ReadFile(cfg.Digest.KeyFile)
+			if err != nil {
+				return fmt.Errorf("digest: %w", err)
+			}
+			if cfg.digestKey = bytes.TrimSpace(bs); len(cfg.digestKey) == 0 {
+				return fmt.Errorf("digest: key file %s is empty", cfg.Digest.KeyFile)
+			}
+		}
+		if cfg.Digest.KeyEnv != "" {
+			if cfg.digestKey = []byte(os.Getenv(cfg.Digest.KeyEnv)); len(cfg.digestKey) == 0 {
+				return fmt.Errorf("digest: environment variable %s is empty", cfg.Digest.KeyEnv)
+			}
+		}
+	}
+
+	cfg.notifiers = nil
+	if cfg.Notify != nil {
+		for i, wc := range cfg.Notify.Webhook {
+			webhook := &notify.Webhook{
+				URL:      wc.URL,
+				Template: wc.Template,
+				Headers:  wc.Headers,
+			}
+			for _, str := range wc.Events {
+				e, err := notify.ParseEventType(str)
+				if err != nil {
+					return fmt.Errorf("notify.webhook[%d]: %w", i, err)
+				}
+				webhook.Events = append(webhook.Events, e)
+			}
+			if err := webhook.Validate(); err != nil {
+				return fmt.Errorf("notify.webhook[%d]: %w", i, err)
+			}
+			cfg.notifiers = append(cfg.notifiers, webhook)
+		}
+	}
 	return nil
 }
 
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseDateTime parses RFC3339, or date time without zone in the location.
+func parseDateTime(str string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, str, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("`%s` is not RFC3339 or `2006-01-02T15:04` format", str)
+}
+
+// parseNotAfter parses the end of the execution window. A date only is the end of the day, not the beginning.
+func parseNotAfter(str string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", str, loc); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return parseDateTime(str, loc)
+}
+
 func (cfg *config) LogDestination(ctx context.Context) (ichigeki.LogDestination, error) {
+	logDestinations, err := cfg.LogDestinations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(logDestinations) == 1 {
+		return logDestinations[0], nil
+	}
+	return ichigeki.MultipleLogDestination(logDestinations), nil
+}
+
+// LogDestinations returns each of the configured log destinations, encrypted if configured.
+// The encrypted log destination compresses the plaintext instead of the inner one, because the ciphertext can not be compressed.
+func (cfg *config) LogDestinations(ctx context.Context) ([]ichigeki.LogDestination, error) {
 	logDestinations := make([]ichigeki.LogDestination, 0, 2)
 	if cfg.S3 != nil && cfg.S3.Bucket != "" {
-		ld, err := s3log.New(ctx, &s3log.Config{
-			Bucket:       cfg.S3.Bucket,
-			ObjectPrefix: cfg.S3.ObjectPrefix,
-		})
+		s3Config := cfg.S3.logConfig()
+		if cfg.encryption != nil {
+			s3Config.Compression = ichigeki.CompressionNone
+		}
+		s3Log, err := s3log.New(ctx, s3Config)
 		if err != nil {
 			return nil, fmt.Errorf("s3 log destination: %w", err)
 		}
+		ld, err := cfg.encrypt(s3Log, cfg.S3.compression)
+		if err != nil {
+			return nil, err
+		}
 		logDestinations = append(logDestinations, ld)
 	}
 	if cfg.File != nil && cfg.File.Dir != "" {
-		logDestinations = append(logDestinations, &ichigeki.LocalFile{
+		localFile := &ichigeki.LocalFile{
 			Path:           cfg.File.Dir,
 			LogFilePostfix: cfg.File.LogFilePostfix,
-		})
+			Compression:    cfg.File.compression,
+		}
+		if cfg.encryption != nil {
+			localFile.Compression = ichigeki.CompressionNone
+		}
+		ld, err := cfg.encrypt(localFile, cfg.File.compression)
+		if err != nil {
+			return nil, err
+		}
+		logDestinations = append(logDestinations, ld)
 	}
 	if len(logDestinations) == 0 {
 		wd, err := os.Getwd()
 		if err != nil {
 			return nil, fmt.Errorf("can not get working directory: %w", err)
 		}
-		logDestinations = append(logDestinations, &ichigeki.LocalFile{
+		ld, err := cfg.encrypt(&ichigeki.LocalFile{
 			Path: wd,
-		})
+		}, ichigeki.CompressionNone)
+		if err != nil {
+			return nil, err
+		}
+		logDestinations = append(logDestinations, ld)
 	}
+	return logDestinations, nil
+}
 
-	if len(logDestinations) == 1 {
-		return logDestinations[0], nil
+// encrypt wraps ld by the encryption compressing the plaintext, if the encryption is configured.
+func (cfg *config) encrypt(ld ichigeki.LogDestination, compression ichigeki.Compression) (ichigeki.LogDestination, error) {
+	if cfg.encryption == nil {
+		return ld, nil
 	}
-	return ichigeki.MultipleLogDestination(logDestinations), nil
+	encryption := *cfg.encryption
+	encryption.Compression = compression
+	return encryptlog.New(ld, &encryption)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/mashiike/ichigeki"
 	"github.com/stretchr/testify/require"
@@ -48,3 +49,231 @@ func TestConfigLoadDefaultNameTemplate(t *testing.T) {
 	}
 	require.EqualValues(t, expected, cfg)
 }
+
+func TestConfigLoadNotify(t *testing.T) {
+	cfg, err := loadConfig("testdata/notify.toml")
+	require.NoError(t, err)
+	expected := &config{
+		Notify: &notifyConfig{
+			Webhook: []*webhookConfig{
+				{
+					URL: "https://hooks.slack.com/services/XXX/YYY/ZZZ",
+				},
+				{
+					URL:      "https://example.com/webhook",
+					Template: `{"event":{{ json .Type }},"name":{{ json .Name }}}`,
+					Events:   []string{"failure", "cancelled"},
+					Headers:  map[string]string{"Authorization": "Bearer xxx"},
+				},
+			},
+		},
+	}
+	require.EqualValues(t, expected, cfg)
+	require.NoError(t, cfg.Restrict())
+	require.Len(t, cfg.notifiers, 2)
+
+	cfg.Notify.Webhook[1].Events = []string{"finished"}
+	require.EqualError(t, cfg.Restrict(), "notify.webhook[1]: unknown event `finished`")
+}
+
+func TestConfigRestrictExecutionWindow(t *testing.T) {
+	cfg := &config{
+		NotBefore:   "2022-06-01T22:00",
+		optNotAfter: "2022-06-02T05:00:00+09:00",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.True(t, time.Date(2022, 6, 1, 22, 0, 0, 0, time.Local).Equal(cfg.notBefore))
+	require.True(t, time.Date(2022, 6, 1, 20, 0, 0, 0, time.UTC).Equal(cfg.notAfter))
+
+	// a date only is the end of the day for not after.
+	cfg = &config{
+		NotBefore: "2022-06-01",
+		NotAfter:  "2022-06-02",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.True(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.Local).Equal(cfg.notBefore))
+	require.True(t, time.Date(2022, 6, 2, 23, 59, 59, 999999999, time.Local).Equal(cfg.notAfter))
+
+	cfg = &config{
+		NotAfter: "tomorrow",
+	}
+	require.EqualError(t, cfg.Restrict(), "not after parse failed: `tomorrow` is not RFC3339 or `2006-01-02T15:04` format")
+}
+
+func TestConfigRestrictTimezone(t *testing.T) {
+	cfg := &config{
+		Timezone:    "Asia/Tokyo",
+		optExecDate: "2022-06-01",
+		NotBefore:   "2022-06-01T22:00",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, "Asia/Tokyo", cfg.location.String())
+	require.True(t, time.Date(2022, 5, 31, 15, 0, 0, 0, time.UTC).Equal(cfg.ExecDate))
+	require.True(t, time.Date(2022, 6, 1, 13, 0, 0, 0, time.UTC).Equal(cfg.notBefore))
+
+	cfg = &config{
+		optTimezone: "Mars/Olympus_Mons",
+	}
+	require.Error(t, cfg.Restrict())
+}
+
+func TestConfigRestrictConfirmer(t *testing.T) {
+	cfg := &config{
+		Confirmer: "env-token",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, &ichigeki.EnvTokenConfirmer{EnvName: "ICHIGEKI_CONFIRM_TOKEN"}, cfg.confirmer)
+
+	cfg = &config{
+		optConfirmer:    "approval-file",
+		optApprovalFile: "/tmp/approval.txt",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, &ichigeki.ApprovalFileConfirmer{Path: "/tmp/approval.txt"}, cfg.confirmer)
+
+	cfg = &config{
+		Confirmer: "approval-file",
+	}
+	require.EqualError(t, cfg.Restrict(), "approval_file is required for confirmer approval-file")
+}
+
+func TestConfigRestrictConfirmTimeout(t *testing.T) {
+	cfg := &config{
+		ConfirmTimeout: "5m",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, 5*time.Minute, cfg.confirmTimeout)
+
+	cfg = &config{
+		ConfirmTimeout:    "5m",
+		optConfirmTimeout: "30s",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, 30*time.Second, cfg.confirmTimeout)
+
+	cfg = &config{
+		ConfirmTimeout: "five minutes",
+	}
+	require.Error(t, cfg.Restrict())
+}
+
+func TestConfigRestrictGracePeriod(t *testing.T) {
+	cfg := &config{}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, 10*time.Second, cfg.gracePeriod)
+
+	cfg = &config{
+		GracePeriod:    "20s",
+		optGracePeriod: "1m",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, time.Minute, cfg.gracePeriod)
+}
+
+func TestConfigRestrictTimeout(t *testing.T) {
+	cfg := &config{
+		Timeout: "30m",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, 30*time.Minute, cfg.timeout)
+
+	cfg = &config{
+		optTimeout: "1h",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, time.Hour, cfg.timeout)
+
+	cfg = &config{
+		Timeout: "forever",
+	}
+	require.Error(t, cfg.Restrict())
+}
+
+func TestConfigRestrictLogFormat(t *testing.T) {
+	cfg := &config{}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, ichigeki.LogFormatRaw, cfg.logFormat)
+
+	cfg = &config{
+		LogFormat: "timestamped",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, ichigeki.LogFormatTimestamped, cfg.logFormat)
+
+	cfg = &config{
+		optLogFormat: "json",
+	}
+	require.EqualError(t, cfg.Restrict(), "log format: unknown log format `json`")
+}
+
+func TestConfigLoadRedact(t *testing.T) {
+	cfg, err := loadConfig("testdata/redact.toml")
+	require.NoError(t, err)
+	expected := &config{
+		Redact: &redactConfig{
+			Env:      []string{"*_PASSWORD", "*_TOKEN"},
+			Patterns: []string{"AKIA[0-9A-Z]{16}"},
+			Mask:     "****",
+		},
+	}
+	require.EqualValues(t, expected, cfg)
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, []string{"*_PASSWORD", "*_TOKEN"}, cfg.redactor.Env)
+	require.Len(t, cfg.redactor.Patterns, 1)
+	require.Equal(t, "****", cfg.redactor.Mask)
+
+	cfg.Redact.Patterns = []string{"AKIA[0-9A-Z"}
+	require.Error(t, cfg.Restrict())
+}
+
+func TestConfigLoadCompression(t *testing.T) {
+	cfg, err := loadConfig("testdata/compression.toml")
+	require.NoError(t, err)
+	expected := &config{
+		S3: &s3Config{
+			Bucket:       "example-com",
+			ObjectPrefix: "hoge/",
+			Compression:  "gzip",
+		},
+		File: &fileConfig{
+			Dir:         "./",
+			Compression: "gzip",
+		},
+	}
+	require.EqualValues(t, expected, cfg)
+	require.NoError(t, cfg.Restrict())
+	require.EqualValues(t, ichigeki.CompressionGzip, cfg.S3.compression)
+	require.EqualValues(t, ichigeki.CompressionGzip, cfg.File.compression)
+
+	cfg.File.Compression = "zstd"
+	require.EqualError(t, cfg.Restrict(), "file compression: unknown compression `zstd`")
+}
+
+func TestConfigLoadS3Options(t *testing.T) {
+	cfg, err := loadConfig("testdata/s3_options.toml")
+	require.NoError(t, err)
+	expected := &config{
+		S3: &s3Config{
+			Bucket:               "example-com",
+			ObjectPrefix:         "hoge/",
+			ServerSideEncryption: "aws:kms",
+			SSEKMSKeyID:          "arn:aws:kms:ap-northeast-1:123456789012:key/00000000-0000-0000-0000-000000000000",
+			StorageClass:         "STANDARD_IA",
+			Tags:                 map[string]string{"team": "sre"},
+			Metadata:             map[string]string{"ticket": "OPS-123"},
+			ExpectedBucketOwner:  "123456789012",
+		},
+	}
+	require.EqualValues(t, expected, cfg)
+	require.NoError(t, cfg.Restrict())
+
+	cfg.S3.ServerSideEncryption = "AES256"
+	require.EqualError(t, cfg.Restrict(), "s3: SSE KMS key ID requires server side encryption `aws:kms`")
+	cfg.S3.SSEKMSKeyID = ""
+	require.NoError(t, cfg.Restrict())
+	cfg.S3.StorageClass = "COLD"
+	require.EqualError(t, cfg.Restrict(), "s3: unknown storage class `COLD`")
+	cfg.S3.StorageClass = ""
+	cfg.S3.Tags["ichigeki:name"] = "hoge"
+	require.EqualError(t, cfg.Restrict(), "s3: tag `ichigeki:name` is reserved")
+}
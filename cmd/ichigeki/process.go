@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals cancel the execution. ECS and Kubernetes send SIGTERM to stop a task.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// notifySignals returns a context canceled by the shutdown signals, and a function that returns the received signal.
+func notifySignals(parent context.Context) (ctx context.Context, received func() os.Signal, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, shutdownSignals...)
+	var (
+		mu  sync.Mutex
+		sig os.Signal
+	)
+	go func() {
+		select {
+		case s := <-ch:
+			mu.Lock()
+			sig = s
+			mu.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	received = func() os.Signal {
+		mu.Lock()
+		defer mu.Unlock()
+		return sig
+	}
+	stop = func() {
+		signal.Stop(ch)
+		cancel()
+	}
+	return ctx, received, stop
+}
+
+// outputDrainTimeout is how long the output is read after the command is killed.
+// A process out of the process group (e.g. a daemon) may keep the pipe open forever.
+const outputDrainTimeout = time.Second
+
+// runCommand runs the command until it exits.
+// When the context is canceled, the process group of the command is terminated with SIGTERM, and killed after the grace period.
+func runCommand(ctx context.Context, cmd *exec.Cmd, grace time.Duration) error {
+	g := newProcessGroup(cmd)
+	outputs, err := pipeOutputs(cmd)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		outputs.close()
+		return err
+	}
+	outputs.start()
+	g.started()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return g.exited(outputs.wait(err))
+	case <-ctx.Done():
+	}
+	g.terminate()
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		select {
+		case <-outputs.done:
+			return g.exited(outputs.wait(err))
+		case <-timer.C:
+		}
+		g.kill()
+		return g.exited(outputs.abandon(err))
+	case <-timer.C:
+	}
+	g.kill()
+	return g.exited(outputs.abandon(<-done))
+}
+
+// outputPipes copy the output of the command instead of exec.Cmd, so that the copy can be abandoned after the command is killed.
+// exec.Cmd waits for the end of the output, which never comes while a grandchild keeps the pipe open.
+type outputPipes struct {
+	writers []*os.File
+	readers []*os.File
+	dsts    []io.Writer
+	done    chan struct{}
+	mu      sync.Mutex
+	err     error
+}
+
+// pipeOutputs replaces Stdout and Stderr of the command with pipes, unless they are files.
+func pipeOutputs(cmd *exec.Cmd) (*outputPipes, error) {
+	p := &outputPipes{done: make(chan struct{})}
+	stdout, err := p.pipe(cmd.Stdout)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	stderr := stdout
+	if !sameWriter(cmd.Stdout, cmd.Stderr) {
+		if stderr, err = p.pipe(cmd.Stderr); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return p, nil
+}
+
+func (p *outputPipes) pipe(w io.Writer) (io.Writer, error) {
+	if w == nil {
+		return nil, nil
+	}
+	if _, ok := w.(*os.File); ok {
+		return w, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.readers = append(p.readers, pr)
+	p.writers = append(p.writers, pw)
+	p.dsts = append(p.dsts, w)
+	return pw, nil
+}
+
+// sameWriter reports whether stdout and stderr are the same writer, as exec.Cmd does, even if it is not comparable.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// start closes the writers in ichigeki, which belong to the command now, and copies the output.
+func (p *outputPipes) start() {
+	for _, w := range p.writers {
+		w.Close()
+	}
+	var wg sync.WaitGroup
+	for i, r := range p.readers {
+		wg.Add(1)
+		go func(dst io.Writer, r *os.File) {
+			defer wg.Done()
+			// the error of the pipe closed by abandon is not of the command.
+			if _, err := io.Copy(dst, r); err != nil && !errors.Is(err, os.ErrClosed) {
+				p.mu.Lock()
+				if p.err == nil {
+					p.err = err
+				}
+				p.mu.Unlock()
+				// keep reading, so that the command is not blocked by the full pipe.
+				io.Copy(io.Discard, r)
+			}
+		}(p.dsts[i], r)
+	}
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+}
+
+// wait waits for the end of the output, and returns the error of the command, or else of the copy.
+func (p *outputPipes) wait(err error) error {
+	<-p.done
+	for _, r := range p.readers {
+		r.Close()
+	}
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// abandon reads the rest of the output for a while, and then closes the pipes without waiting for the end.
+func (p *outputPipes) abandon(err error) error {
+	timer := time.NewTimer(outputDrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+	case <-timer.C:
+		p.close()
+	}
+	return p.wait(err)
+}
+
+func (p *outputPipes) close() {
+	for _, f := range p.writers {
+		f.Close()
+	}
+	for _, f := range p.readers {
+		f.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+const ttyHelperEnv = "ICHIGEKI_TEST_TTY_HELPER"
+
+// TestRunCommandTTY runs TestRunCommandTTYHelper in a new session with a pseudo terminal as the controlling terminal and stdin,
+// as ichigeki runs in an interactive shell.
+func TestRunCommandTTY(t *testing.T) {
+	cases := []struct {
+		name string
+		keys string
+	}{
+		{name: "terminate_process_group"},
+		{name: "ctrl_c", keys: "\x03"},
+		{name: "ctrl_z", keys: "\x1a"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			master, slave := openPTY(t)
+			defer master.Close()
+			var (
+				mu     sync.Mutex
+				output bytes.Buffer
+			)
+			read := make(chan struct{})
+			go func() {
+				defer close(read)
+				buf := make([]byte, 1024)
+				for {
+					n, err := master.Read(buf)
+					mu.Lock()
+					output.Write(buf[:n])
+					mu.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestRunCommandTTYHelper$", "-test.v")
+			cmd.Env = append(os.Environ(), ttyHelperEnv+"="+c.name, "ICHIGEKI_TEST_TTY_DIR="+t.TempDir())
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+			require.NoError(t, cmd.Start())
+			slave.Close()
+			if c.keys != "" {
+				time.Sleep(time.Second)
+				_, err := master.Write([]byte(c.keys))
+				require.NoError(t, err)
+			}
+			err := cmd.Wait()
+			select {
+			case <-read:
+			case <-time.After(5 * time.Second):
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			require.NoError(t, err, output.String())
+			require.Contains(t, output.String(), "--- PASS: TestRunCommandTTYHelper")
+		})
+	}
+}
+
+func TestRunCommandTTYHelper(t *testing.T) {
+	name := os.Getenv(ttyHelperEnv)
+	if name == "" {
+		t.Skip("run by TestRunCommandTTY")
+	}
+	dir := os.Getenv("ICHIGEKI_TEST_TTY_DIR")
+	pgrp, err := tcgetpgrp(os.Stdin)
+	require.NoError(t, err)
+	require.Equal(t, syscall.Getpgrp(), pgrp, "not in the foreground")
+
+	switch name {
+	case "terminate_process_group":
+		marker := filepath.Join(dir, "marker")
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		cmd := exec.Command("sh", "-c", `(sleep 1; echo alive > "$0") & wait`, marker)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = io.Discard
+		err := runCommand(ctx, cmd, time.Second)
+		require.Error(t, err)
+		time.Sleep(1500 * time.Millisecond)
+		_, err = os.Stat(marker)
+		require.True(t, os.IsNotExist(err), "grandchild is not terminated")
+	case "ctrl_c":
+		cmd := exec.Command("sleep", "5")
+		cmd.Stdin = os.Stdin
+		err := runCommand(context.Background(), cmd, time.Second)
+		var interrupted *ichigeki.InterruptedError
+		require.True(t, errors.As(err, &interrupted), fmt.Sprintf("%v", err))
+		require.Equal(t, syscall.SIGINT, interrupted.Signal)
+	case "ctrl_z":
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", "sleep 2; echo done")
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = &stdout
+		require.NoError(t, runCommand(context.Background(), cmd, time.Second))
+		require.Equal(t, "done\n", stdout.String())
+	}
+	pgrp, err = tcgetpgrp(os.Stdin)
+	require.NoError(t, err)
+	require.Equal(t, syscall.Getpgrp(), pgrp, "the terminal is not taken back")
+}
+
+func openPTY(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("pseudo terminal is not available: %s", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skipf("pseudo terminal is not available: %s", errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Skipf("pseudo terminal is not available: %s", errno)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Skipf("pseudo terminal is not available: %s", err)
+	}
+	return master, slave
+}
@@ -0,0 +1,106 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/mashiike/ichigeki"
+)
+
+// processGroup starts the command in a new process group, so that terminating it also terminates the grandchildren.
+// If stdin is the terminal and ichigeki is in the foreground, the process group of the command is placed in the foreground,
+// so that the command can read the terminal, and the keys of the terminal (e.g. Ctrl-C) reach the command and the grandchildren.
+type processGroup struct {
+	cmd *exec.Cmd
+	// tty is the terminal given to the command, nil if the command is not in the foreground.
+	tty  *os.File
+	chld chan os.Signal
+	stop chan struct{}
+}
+
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	g := &processGroup{cmd: cmd}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if f, ok := cmd.Stdin.(*os.File); ok {
+		if pgrp, err := tcgetpgrp(f); err == nil && pgrp == syscall.Getpgrp() {
+			g.tty = f
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = int(f.Fd())
+		}
+	}
+	return g
+}
+
+// started resumes the command whenever it is stopped, e.g. by Ctrl-Z, while it is in the foreground.
+// ichigeki waits for the command in the background, so nobody could resume it from the terminal.
+func (g *processGroup) started() {
+	if g.tty == nil {
+		return
+	}
+	g.chld = make(chan os.Signal, 1)
+	g.stop = make(chan struct{})
+	signal.Notify(g.chld, syscall.SIGCHLD)
+	go func() {
+		for {
+			select {
+			case <-g.chld:
+				syscall.Kill(-g.cmd.Process.Pid, syscall.SIGCONT)
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// exited takes the terminal back to ichigeki.
+// If the command is killed by Ctrl-C or Ctrl-\ of the terminal, which ichigeki in the background does not receive, it returns InterruptedError.
+func (g *processGroup) exited(err error) error {
+	if g.tty == nil {
+		return err
+	}
+	signal.Stop(g.chld)
+	close(g.stop)
+	// ichigeki is in the background until it takes the terminal back, and SIGTTOU would stop it.
+	signal.Ignore(syscall.SIGTTOU)
+	tcsetpgrp(g.tty, syscall.Getpgrp())
+	signal.Reset(syscall.SIGTTOU)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			switch sig := status.Signal(); sig {
+			case syscall.SIGINT, syscall.SIGQUIT:
+				return &ichigeki.InterruptedError{Signal: sig, Err: err}
+			}
+		}
+	}
+	return err
+}
+
+func (g *processGroup) terminate() {
+	syscall.Kill(-g.cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func (g *processGroup) kill() {
+	syscall.Kill(-g.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func tcgetpgrp(f *os.File) (int, error) {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+func tcsetpgrp(f *os.File, pgrp int) error {
+	p := int32(pgrp)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&p))); errno != 0 {
+		return errno
+	}
+	return nil
+}
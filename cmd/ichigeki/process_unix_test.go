@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand(t *testing.T) {
+	t.Run("terminate_process_group", func(t *testing.T) {
+		marker := filepath.Join(t.TempDir(), "marker")
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		cmd := exec.Command("sh", "-c", `(sleep 1; echo alive > "$0") & wait`, marker)
+		err := runCommand(ctx, cmd, time.Second)
+		require.Error(t, err)
+		time.Sleep(1500 * time.Millisecond)
+		_, err = os.Stat(marker)
+		require.True(t, os.IsNotExist(err), "grandchild is not terminated")
+	})
+	t.Run("graceful", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", `trap 'echo bye; exit 0' TERM; sleep 5 & wait`)
+		cmd.Stdout = &stdout
+		require.NoError(t, runCommand(ctx, cmd, 5*time.Second))
+		require.Equal(t, "bye\n", stdout.String())
+	})
+	t.Run("kill_after_grace_period", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		cmd := exec.Command("sh", "-c", `trap '' TERM; sleep 5`)
+		err := runCommand(ctx, cmd, 300*time.Millisecond)
+		require.Error(t, err)
+		require.Less(t, int64(time.Since(start)), int64(3*time.Second))
+	})
+	t.Run("abandon_output_of_escaped_grandchild", func(t *testing.T) {
+		if _, err := exec.LookPath("setsid"); err != nil {
+			t.Skip("setsid is not found")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", `setsid sleep 10 & echo started; sleep 5`)
+		cmd.Stdout = &stdout
+		err := runCommand(ctx, cmd, 300*time.Millisecond)
+		require.Error(t, err)
+		require.Less(t, int64(time.Since(start)), int64(3*time.Second))
+		require.Equal(t, "started\n", stdout.String())
+	})
+}
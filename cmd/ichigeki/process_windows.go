@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+// processGroup only kills the command on Windows, which can not send signals to a process group.
+type processGroup struct {
+	cmd *exec.Cmd
+}
+
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	return &processGroup{cmd: cmd}
+}
+
+func (g *processGroup) started() {}
+
+func (g *processGroup) exited(err error) error {
+	return err
+}
+
+// terminate kills the command, because Windows has no SIGTERM.
+func (g *processGroup) terminate() {
+	g.cmd.Process.Kill()
+}
+
+func (g *processGroup) kill() {
+	g.cmd.Process.Kill()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+func showCommand(ctx context.Context, args []string) error {
+	cfg, err := defaultConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "ichigeki show [options] <name>")
+		fs.PrintDefaults()
+	}
+	cfg.SetDestinationFlags(fs)
+	var metaOnly bool
+	fs.BoolVar(&metaOnly, "meta-only", false, "print only the metadata of the header and footer as JSON")
+	name, err := parseWithName(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Restrict(); err != nil {
+		return err
+	}
+	ld, err := cfg.LogDestination(ctx)
+	if err != nil {
+		return err
+	}
+	return showExecution(ctx, os.Stdout, ld, name, metaOnly)
+}
+
+// parseWithName parses the flags before and after the positional name argument.
+func parseWithName(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", errors.New("name is required")
+	}
+	name := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return "", fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return name, nil
+}
+
+func showExecution(ctx context.Context, w io.Writer, ld ichigeki.LogDestination, name string, metaOnly bool) error {
+	reader, ok := ld.(ichigeki.Reader)
+	if !ok {
+		return fmt.Errorf("log destination [%s] can not be read", ld.String())
+	}
+	ld.SetName(name)
+	r, err := reader.NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("read log destination [%s]: %w", ld.String(), err)
+	}
+	defer r.Close()
+	if metaOnly {
+		// not by ichigeki.ReadMetadata, which leaves the footer of the compressed S3 log partly empty.
+		l, err := logparse.ParseMetadata(r)
+		if err != nil {
+			return fmt.Errorf("read log destination [%s]: %w", ld.String(), err)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(execution{Name: name, Log: l})
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("read log destination [%s]: %w", ld.String(), err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowExecution(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	defer restore()
+	tempDir := t.TempDir()
+	h := &ichigeki.Hissatsu{
+		Name: "migration",
+		LogDestination: &ichigeki.LocalFile{
+			Path: tempDir,
+		},
+		ConfirmDialog: ichigeki.Bool(false),
+		Script: func(_ ichigeki.Context, stdout io.Writer, _ io.Writer) error {
+			fmt.Fprint(stdout, "migrated")
+			return nil
+		},
+	}
+	require.NoError(t, h.Execute())
+
+	ld := &ichigeki.LocalFile{Path: tempDir}
+	var buf bytes.Buffer
+	require.NoError(t, showExecution(context.Background(), &buf, ld, "migration", false))
+	expected, err := os.ReadFile(filepath.Join(tempDir, "migration.log"))
+	require.NoError(t, err)
+	require.EqualValues(t, string(expected), buf.String())
+
+	buf.Reset()
+	require.NoError(t, showExecution(context.Background(), &buf, ld, "migration", true))
+	var meta map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &meta))
+	require.EqualValues(t, "migration", meta["name"])
+	require.EqualValues(t, h.RunID, meta["run_id"])
+	require.EqualValues(t, "success", meta["outcome"])
+
+	require.Error(t, showExecution(context.Background(), &buf, ld, "not_found", false))
+}
+
+func TestParseWithName(t *testing.T) {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	var metaOnly bool
+	fs.BoolVar(&metaOnly, "meta-only", false, "")
+	name, err := parseWithName(fs, []string{"migration", "--meta-only"})
+	require.NoError(t, err)
+	require.EqualValues(t, "migration", name)
+	require.True(t, metaOnly)
+}
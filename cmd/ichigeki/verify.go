@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+func verifyCommand(ctx context.Context, args []string) error {
+	cfg, err := defaultConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "ichigeki verify [options] <name>")
+		fs.PrintDefaults()
+	}
+	cfg.SetDestinationFlags(fs)
+	cfg.SetDigestFlags(fs)
+	name, err := parseWithName(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Restrict(); err != nil {
+		return err
+	}
+	lds, err := cfg.LogDestinations(ctx)
+	if err != nil {
+		return err
+	}
+	return verifyExecution(ctx, os.Stdout, lds, name, cfg.digestKey)
+}
+
+// verifyExecution checks the digest of the execution log in each log destination, so that a copy edited in any of them is found.
+func verifyExecution(ctx context.Context, w io.Writer, lds []ichigeki.LogDestination, name string, key []byte) error {
+	failed := 0
+	for _, ld := range lds {
+		ld.SetName(name)
+		digest, err := verifyLog(ctx, ld, key)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "NG\t%s\t%s\n", ld.String(), err)
+			continue
+		}
+		fmt.Fprintf(w, "OK\t%s\t%s\n", ld.String(), digest)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d log destinations failed to verify", failed, len(lds))
+	}
+	return nil
+}
+
+func verifyLog(ctx context.Context, ld ichigeki.LogDestination, key []byte) (string, error) {
+	reader, ok := ld.(ichigeki.Reader)
+	if !ok {
+		return "", errors.New("can not be read")
+	}
+	if exists, err := ld.AlreadyExists(ctx); err != nil {
+		return "", err
+	} else if !exists {
+		return "", errors.New("not found")
+	}
+	r, err := reader.NewReader(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	l, err := logparse.Verify(r, key)
+	if err != nil {
+		return "", err
+	}
+	return l.Digest, nil
+}
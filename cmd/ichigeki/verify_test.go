@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyExecution(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	defer restore()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	key := []byte("s3cr3t")
+	lds := []ichigeki.LogDestination{
+		&ichigeki.LocalFile{Path: dirs[0]},
+		&ichigeki.LocalFile{Path: dirs[1]},
+	}
+	h := &ichigeki.Hissatsu{
+		Name:           "migration",
+		LogDestination: ichigeki.MultipleLogDestination(lds),
+		ConfirmDialog:  ichigeki.Bool(false),
+		DigestKey:      key,
+		Script: func(_ ichigeki.Context, stdout io.Writer, _ io.Writer) error {
+			fmt.Fprint(stdout, "deleted 3 rows")
+			return nil
+		},
+	}
+	require.NoError(t, h.Execute())
+
+	var buf bytes.Buffer
+	require.NoError(t, verifyExecution(context.Background(), &buf, lds, "migration", key))
+	require.Regexp(t, "^OK\t.+migration\\.log\thmac-sha256:[0-9a-f]{64}\nOK\t.+migration\\.log\thmac-sha256:[0-9a-f]{64}\n$", buf.String())
+
+	edited := filepath.Join(dirs[1], "migration.log")
+	bs, err := os.ReadFile(edited)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(edited, []byte(strings.Replace(string(bs), "deleted 3 rows", "deleted 1 row", 1)), 0644))
+	buf.Reset()
+	err = verifyExecution(context.Background(), &buf, lds, "migration", key)
+	require.EqualError(t, err, "1 of 2 log destinations failed to verify")
+	require.Contains(t, buf.String(), "NG\t"+edited+"\tdigest mismatch\n")
+
+	buf.Reset()
+	err = verifyExecution(context.Background(), &buf, lds, "not_executed", key)
+	require.EqualError(t, err, "2 of 2 log destinations failed to verify")
+	require.Contains(t, buf.String(), "\tnot found\n")
+}
+
+func TestConfigRestrictDigestKey(t *testing.T) {
+	tempDir := t.TempDir()
+	keyFile := filepath.Join(tempDir, "digest.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("s3cr3t\n"), 0600))
+	cfg := &config{
+		Digest: &digestConfig{KeyFile: keyFile},
+	}
+	require.NoError(t, cfg.Restrict())
+	require.Equal(t, []byte("s3cr3t"), cfg.digestKey)
+
+	os.Setenv("ICHIGEKI_TEST_DIGEST_KEY", "an0ther")
+	defer os.Unsetenv("ICHIGEKI_TEST_DIGEST_KEY")
+	cfg = &config{
+		Digest:          &digestConfig{KeyFile: keyFile},
+		optDigestKeyEnv: "ICHIGEKI_TEST_DIGEST_KEY",
+	}
+	require.NoError(t, cfg.Restrict())
+	require.Equal(t, []byte("an0ther"), cfg.digestKey)
+
+	cfg = &config{
+		optDigestKeyEnv: "ICHIGEKI_TEST_DIGEST_KEY_NOT_SET",
+	}
+	require.EqualError(t, cfg.Restrict(), "digest: environment variable ICHIGEKI_TEST_DIGEST_KEY_NOT_SET is empty")
+
+	cfg = &config{}
+	require.NoError(t, cfg.Restrict())
+	require.Nil(t, cfg.digestKey)
+}
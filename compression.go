@@ -0,0 +1,123 @@
+package ichigeki
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression is the compression of the stored execution log.
+type Compression int
+
+const (
+	// CompressionNone stores the execution log as is. (default)
+	CompressionNone Compression = iota
+	// CompressionGzip stores the execution log as `name.log.gz`.
+	CompressionGzip
+)
+
+// Compressions are all of the compressions. A log destination checks the execution log in any of them,
+// so that changing the compression never allows a second execution.
+var Compressions = []Compression{CompressionNone, CompressionGzip}
+
+func ParseCompression(str string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression `%s`", str)
+}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// Extension is appended to the name of the compressed execution log, e.g. `.gz`.
+func (c Compression) Extension() string {
+	if c == CompressionGzip {
+		return ".gz"
+	}
+	return ""
+}
+
+// ContentEncoding is the HTTP Content-Encoding of the compressed execution log.
+func (c Compression) ContentEncoding() string {
+	if c == CompressionGzip {
+		return "gzip"
+	}
+	return ""
+}
+
+// SearchOrder returns c first, and then the other Compressions, so that the execution log in the configured compression is preferred.
+func (c Compression) SearchOrder() []Compression {
+	cs := make([]Compression, 0, len(Compressions))
+	cs = append(cs, c)
+	for _, other := range Compressions {
+		if other != c {
+			cs = append(cs, other)
+		}
+	}
+	return cs
+}
+
+// NewWriter returns the writer compressing to w. Close flushes the compressed data, but does not close w.
+func (c Compression) NewWriter(w io.Writer) io.WriteCloser {
+	if c == CompressionGzip {
+		return gzip.NewWriter(w)
+	}
+	return nopWriteCloser{w}
+}
+
+// NewReader returns the reader decompressing r. Close closes r.
+// An empty or truncated execution log, e.g. of a claimed name or a crashed execution, is read as far as it is written.
+func (c Compression) NewReader(r io.ReadCloser) (io.ReadCloser, error) {
+	if c != CompressionGzip {
+		return r, nil
+	}
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+		return r, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &decompressReader{r: zr, closer: r}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type decompressReader struct {
+	r      io.Reader
+	closer io.Closer
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *decompressReader) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,286 @@
+package ichigeki
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrCanceled is returned when the execution is not confirmed.
+var ErrCanceled = errors.New("canceled.")
+
+// Confirmer confirms the execution before the name is claimed.
+// If the execution is not approved, Confirm returns an error that wraps ErrCanceled.
+type Confirmer interface {
+	Confirm(ctx context.Context, req *ConfirmRequest) (*Confirmation, error)
+}
+
+// ConfirmRequest is what is about to be executed.
+type ConfirmRequest struct {
+	Name           string
+	ExecDate       string
+	RunID          string
+	DryRun         bool
+	LogDestination string
+	// Message is DialogMessage formatted with the name.
+	Message string
+}
+
+// Confirmation is the result of the approved confirmation, recorded in the header of the execution log.
+type Confirmation struct {
+	// Confirmer is the identity of the confirmer, e.g. `prompt`, `env-token(ICHIGEKI_CONFIRM_TOKEN)`.
+	Confirmer string
+	// ConfirmMode is how the execution was confirmed, if the confirmer has modes.
+	ConfirmMode string
+	// ConfirmedBy is who approved the execution.
+	ConfirmedBy string
+}
+
+// ConfirmMode is how the operator confirms the execution in the confirm dialog.
+type ConfirmMode int
+
+const (
+	// ConfirmYesNo accepts `y` or `yes`. (default)
+	ConfirmYesNo ConfirmMode = iota
+	// ConfirmTypeName requires to type the execution name, like GitHub's confirmation for destructive operations.
+	ConfirmTypeName
+	// ConfirmTypePhrase requires to type ConfirmPhrase.
+	ConfirmTypePhrase
+)
+
+func ParseConfirmMode(str string) (ConfirmMode, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "yes-no":
+		return ConfirmYesNo, nil
+	case "type-name":
+		return ConfirmTypeName, nil
+	case "type-phrase":
+		return ConfirmTypePhrase, nil
+	}
+	return ConfirmYesNo, fmt.Errorf("unknown confirm mode `%s`", str)
+}
+
+func (m ConfirmMode) String() string {
+	switch m {
+	case ConfirmYesNo:
+		return "yes-no"
+	case ConfirmTypeName:
+		return "type-name"
+	case ConfirmTypePhrase:
+		return "type-phrase"
+	}
+	return fmt.Sprintf("ConfirmMode(%d)", int(m))
+}
+
+var (
+	// ErrNoTTY is returned when the confirm dialog is required but there is no terminal to show it.
+	ErrNoTTY = errors.New("confirmation is required, but no terminal is available")
+	// ErrConfirmTimeout is returned when nobody answers the confirm dialog within the timeout.
+	ErrConfirmTimeout = errors.New("confirmation timed out")
+)
+
+// PromptConfirmer shows the confirm dialog to Output and reads the response from Input.
+// If Input is nil, the dialog uses the controlling terminal, so that the stdin piped to the script is never consumed.
+type PromptConfirmer struct {
+	Input  io.Reader
+	Output io.Writer
+	Mode   ConfirmMode
+	// Phrase is required for ConfirmTypePhrase.
+	Phrase string
+	// Timeout aborts the confirm dialog with ErrConfirmTimeout. zero means no timeout.
+	Timeout time.Duration
+}
+
+func (c *PromptConfirmer) Confirm(ctx context.Context, req *ConfirmRequest) (*Confirmation, error) {
+	input, output := c.Input, c.Output
+	if input == nil {
+		in, out, err := openTTY()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoTTY, err)
+		}
+		defer in.Close()
+		if out != in {
+			defer out.Close()
+		}
+		input = in
+		if output == nil {
+			output = out
+		}
+	}
+	if f, ok := input.(*os.File); ok && !isTerminal(f) {
+		return nil, fmt.Errorf("%w: %s is not a terminal", ErrNoTTY, f.Name())
+	}
+	if output == nil {
+		output = os.Stderr
+	}
+	var expected string
+	switch c.Mode {
+	case ConfirmTypeName:
+		expected = req.Name
+		fmt.Fprintf(output, "%s\nType the name `%s` to confirm:", req.Message, req.Name)
+	case ConfirmTypePhrase:
+		if c.Phrase == "" {
+			return nil, errors.New("Phrase is required for ConfirmTypePhrase")
+		}
+		expected = c.Phrase
+		fmt.Fprintf(output, "%s\nType `%s` to confirm:", req.Message, c.Phrase)
+	default:
+		fmt.Fprintf(output, "%s [y/n]:", req.Message)
+	}
+	response, err := readResponse(ctx, input, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	response = strings.TrimSpace(response)
+	if c.Mode == ConfirmYesNo {
+		response = strings.ToLower(response)
+		if response != "y" && response != "yes" {
+			return nil, ErrCanceled
+		}
+	} else if response != expected {
+		return nil, ErrCanceled
+	}
+	return &Confirmation{
+		Confirmer:   "prompt",
+		ConfirmMode: c.Mode.String(),
+		ConfirmedBy: username(),
+	}, nil
+}
+
+// readResponse reads a line, giving up when the context is done or the timeout expires.
+func readResponse(ctx context.Context, r io.Reader, timeout time.Duration) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		ch <- result{line: line, err: err}
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return "", fmt.Errorf("prompt error: %w", res.err)
+		}
+		return res.line, nil
+	case <-ctx.Done():
+		return "", withKind(ErrCanceled, fmt.Errorf("prompt canceled: %w", ctx.Err()))
+	case <-expired:
+		return "", fmt.Errorf("%w: no response in %s", ErrConfirmTimeout, timeout)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is also a character device.
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// AlwaysYes approves every execution without asking. It is used when ConfirmDialog is false.
+type AlwaysYes struct{}
+
+func (AlwaysYes) Confirm(_ context.Context, _ *ConfirmRequest) (*Confirmation, error) {
+	return &Confirmation{
+		Confirmer: "always-yes",
+	}, nil
+}
+
+// EnvTokenConfirmer approves the execution when the environment variable EnvName equals Token.
+// If Token is empty, the environment variable must equal the execution name, so that a pipeline has to name what it approves.
+type EnvTokenConfirmer struct {
+	EnvName string
+	Token   string
+}
+
+func (c *EnvTokenConfirmer) Confirm(_ context.Context, req *ConfirmRequest) (*Confirmation, error) {
+	if c.EnvName == "" {
+		return nil, errors.New("EnvName is required")
+	}
+	expected := c.Token
+	if expected == "" {
+		expected = req.Name
+	}
+	actual, ok := os.LookupEnv(c.EnvName)
+	if !ok {
+		return nil, fmt.Errorf("%s is not set: %w", c.EnvName, ErrCanceled)
+	}
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 {
+		return nil, fmt.Errorf("%s does not match: %w", c.EnvName, ErrCanceled)
+	}
+	return &Confirmation{
+		Confirmer:   fmt.Sprintf("env-token(%s)", c.EnvName),
+		ConfirmedBy: username(),
+	}, nil
+}
+
+// ApprovalFileConfirmer approves the execution when the file at Path exists and approves the execution name.
+// The approval file is `key: value` lines, the same as the header of the execution log:
+//
+//	name: <execution name>
+//	approved_by: <who approved>
+type ApprovalFileConfirmer struct {
+	Path string
+}
+
+func (c *ApprovalFileConfirmer) Confirm(_ context.Context, req *ConfirmRequest) (*Confirmation, error) {
+	fp, err := os.Open(c.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("approval file %s not found: %w", c.Path, ErrCanceled)
+		}
+		return nil, err
+	}
+	defer fp.Close()
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		if i := strings.Index(scanner.Text(), ":"); i > 0 {
+			fields[strings.TrimSpace(scanner.Text()[:i])] = strings.TrimSpace(scanner.Text()[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if fields["name"] != req.Name {
+		return nil, fmt.Errorf("approval file %s does not approve `%s`: %w", c.Path, req.Name, ErrCanceled)
+	}
+	return &Confirmation{
+		Confirmer:   fmt.Sprintf("approval-file(%s)", c.Path),
+		ConfirmedBy: fields["approved_by"],
+	}, nil
+}
+
+// confirmer returns Confirmer, or the default confirmer decided by ConfirmDialog.
+func (h *Hissatsu) confirmer() Confirmer {
+	if h.Confirmer != nil {
+		return h.Confirmer
+	}
+	if !*h.ConfirmDialog {
+		return AlwaysYes{}
+	}
+	return &PromptConfirmer{
+		Input:   h.PromptInput,
+		Mode:    h.ConfirmMode,
+		Phrase:  h.ConfirmPhrase,
+		Timeout: h.ConfirmTimeout,
+	}
+}
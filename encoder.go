@@ -0,0 +1,130 @@
+package ichigeki
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// LogFormat is the format of the body of the execution log.
+type LogFormat int
+
+const (
+	// LogFormatRaw writes stdout and stderr of the script as is. (default)
+	LogFormatRaw LogFormat = iota
+	// LogFormatTimestamped prefixes each line with the time the line was printed and the stream, e.g.
+	//
+	//	2022-06-05T12:00:00.123456789+09:00 [out] processing 1
+	LogFormatTimestamped
+)
+
+func ParseLogFormat(str string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "raw":
+		return LogFormatRaw, nil
+	case "timestamped":
+		return LogFormatTimestamped, nil
+	}
+	return LogFormatRaw, fmt.Errorf("unknown log format `%s`", str)
+}
+
+func (f LogFormat) String() string {
+	switch f {
+	case LogFormatRaw:
+		return "raw"
+	case LogFormatTimestamped:
+		return "timestamped"
+	}
+	return fmt.Sprintf("LogFormat(%d)", int(f))
+}
+
+// maxLineLength is the length to write a line without newline, not to buffer the output of the script unlimitedly.
+const maxLineLength = 64 * 1024
+
+// lineEncoder writes each line of a stream at once, so that the lines of stdout and stderr are not garbled in the shared log.
+// In LogFormatTimestamped, each line is prefixed with the timestamp and the tag of the stream.
+// A partial line is buffered until the newline or Flush.
+type lineEncoder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format LogFormat
+	redact *Redactor
+	tag    string
+	loc    *time.Location
+	buf    []byte
+	start  time.Time
+}
+
+func newLineEncoder(w io.Writer, format LogFormat, redact *Redactor, tag string, loc *time.Location) *lineEncoder {
+	return &lineEncoder{
+		w:      w,
+		format: format,
+		redact: redact,
+		tag:    tag,
+		loc:    loc,
+	}
+}
+
+func (e *lineEncoder) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == 0 {
+			e.start = flextime.Now()
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			e.buf = append(e.buf, p...)
+			if len(e.buf) >= maxLineLength {
+				// the tail is kept not to split a secret, which is redacted only as a whole.
+				if err := e.emit(e.redact.splitPoint(e.buf), true); err != nil {
+					return n, err
+				}
+			}
+			break
+		}
+		e.buf = append(e.buf, p[:i+1]...)
+		p = p[i+1:]
+		if err := e.emit(len(e.buf), false); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the buffered partial line without newline.
+func (e *lineEncoder) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.buf) == 0 {
+		return nil
+	}
+	return e.emit(len(e.buf), false)
+}
+
+// emit writes the first n bytes of the buffer and keeps the rest.
+// In LogFormatTimestamped, the written bytes are terminated with newline if split is true.
+func (e *lineEncoder) emit(n int, split bool) error {
+	buf := e.redact.redact(e.buf[:n])
+	if e.format == LogFormatTimestamped {
+		line := make([]byte, 0, len(buf)+48)
+		line = e.start.In(e.loc).AppendFormat(line, time.RFC3339Nano)
+		line = append(line, ' ')
+		line = append(line, e.tag...)
+		line = append(line, ' ')
+		line = append(line, buf...)
+		if split {
+			line = append(line, '\n')
+		}
+		buf = line
+	}
+	_, err := e.w.Write(buf)
+	e.buf = e.buf[:copy(e.buf, e.buf[n:])]
+	return err
+}
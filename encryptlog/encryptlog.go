@@ -0,0 +1,217 @@
+// Package encryptlog encrypts the execution log on the client side, wrapping any ichigeki.LogDestination.
+package encryptlog
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mashiike/ichigeki"
+)
+
+const (
+	// keySize is the size of the key of AES-256-GCM.
+	keySize = 32
+	// DefaultIterations is the iterations of PBKDF2-HMAC-SHA256 to derive the key from the passphrase.
+	DefaultIterations = 600000
+	maxIterations     = 100000000
+)
+
+var (
+	_ ichigeki.Claimer        = (*LogDestination)(nil)
+	_ ichigeki.Releaser       = (*LogDestination)(nil)
+	_ ichigeki.Reader         = (*LogDestination)(nil)
+	_ ichigeki.Archiver       = (*LogDestination)(nil)
+	_ ichigeki.Lister         = (*LogDestination)(nil)
+	_ ichigeki.MetadataSetter = (*LogDestination)(nil)
+)
+
+// Config is the key of the encryption. Either Key or Passphrase is required.
+type Config struct {
+	// Key is the 256-bit key of AES-256-GCM.
+	Key []byte
+	// Passphrase derives the key by PBKDF2-HMAC-SHA256 with the random salt of each log.
+	Passphrase string
+	// Iterations of PBKDF2. If zero, DefaultIterations is used.
+	Iterations int
+	// Compression compresses the plaintext before the encryption, because the ciphertext can not be compressed.
+	// The destination wrapped by the encryption should not compress.
+	Compression ichigeki.Compression
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Key == nil && cfg.Passphrase == "" {
+		return errors.New("key or passphrase is required")
+	}
+	if cfg.Key != nil && cfg.Passphrase != "" {
+		return errors.New("key and passphrase are exclusive")
+	}
+	if cfg.Key != nil && len(cfg.Key) != keySize {
+		return fmt.Errorf("key must be %d bytes, but %d bytes", keySize, len(cfg.Key))
+	}
+	if cfg.Iterations < 0 || cfg.Iterations > maxIterations {
+		return fmt.Errorf("invalid iterations %d", cfg.Iterations)
+	}
+	if !knownCompression(cfg.Compression) {
+		return fmt.Errorf("invalid compression %d", cfg.Compression)
+	}
+	return nil
+}
+
+func (cfg *Config) iterations() int {
+	if cfg.Iterations == 0 {
+		return DefaultIterations
+	}
+	return cfg.Iterations
+}
+
+// LoadKeyFile reads the 256-bit key from the file: 32 bytes as is, or encoded in hex or base64 (e.g. `openssl rand -base64 32`).
+func LoadKeyFile(path string) ([]byte, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) == keySize {
+		return bs, nil
+	}
+	str := strings.TrimSpace(string(bs))
+	if key, err := hex.DecodeString(str); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(str); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: key file must contain a %d-bit key (%d bytes, or encoded in hex or base64)", path, keySize*8, keySize)
+}
+
+// LogDestination encrypts the execution log written to the inner log destination, and decrypts it read from there.
+// The names, the existence and the claim are of the inner log destination as they are.
+type LogDestination struct {
+	inner   ichigeki.LogDestination
+	cfg     *Config
+	writers []*Writer
+}
+
+func New(inner ichigeki.LogDestination, cfg *Config) (*LogDestination, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &LogDestination{
+		inner: inner,
+		cfg:   cfg,
+	}, nil
+}
+
+func (ld *LogDestination) AlreadyExists(ctx context.Context) (bool, error) {
+	return ld.inner.AlreadyExists(ctx)
+}
+
+// Claim claims the name in the inner log destination, if it is a Claimer.
+func (ld *LogDestination) Claim(ctx context.Context) error {
+	if claimer, ok := ld.inner.(ichigeki.Claimer); ok {
+		return claimer.Claim(ctx)
+	}
+	return nil
+}
+
+// Release releases the name in the inner log destination, if it is a Releaser.
+func (ld *LogDestination) Release(ctx context.Context) error {
+	if releaser, ok := ld.inner.(ichigeki.Releaser); ok {
+		return releaser.Release(ctx)
+	}
+	return nil
+}
+
+func (ld *LogDestination) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	reader, ok := ld.inner.(ichigeki.Reader)
+	if !ok {
+		return nil, fmt.Errorf("%s: can not be read", ld.inner.String())
+	}
+	rc, err := reader.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: NewReader(rc, ld.cfg), closer: rc}, nil
+}
+
+// Archive archives the log in the inner log destination, which is verified after the decryption.
+func (ld *LogDestination) Archive(ctx context.Context, suffix string, verify func(r io.Reader) error) error {
+	archiver, ok := ld.inner.(ichigeki.Archiver)
+	if !ok {
+		return fmt.Errorf("%s: can not be archived", ld.inner.String())
+	}
+	return archiver.Archive(ctx, suffix, func(r io.Reader) error {
+		return verify(NewReader(r, ld.cfg))
+	})
+}
+
+func (ld *LogDestination) List(ctx context.Context, namePrefix string) ([]ichigeki.LogEntry, error) {
+	lister, ok := ld.inner.(ichigeki.Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s: can not be listed", ld.inner.String())
+	}
+	return lister.List(ctx, namePrefix)
+}
+
+func (ld *LogDestination) NewWriter(ctx context.Context) (io.Writer, io.Writer, error) {
+	stdout, stderr, err := ld.inner.NewWriter(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ld.writers = nil
+	wout, err := NewWriter(stdout, ld.cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypt: %w", err)
+	}
+	ld.writers = append(ld.writers, wout)
+	if stdout == stderr {
+		return wout, wout, nil
+	}
+	werr, err := NewWriter(stderr, ld.cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypt: %w", err)
+	}
+	ld.writers = append(ld.writers, werr)
+	return wout, werr, nil
+}
+
+// Cleanup writes the last frame, and then cleans up the inner log destination.
+func (ld *LogDestination) Cleanup(ctx context.Context) {
+	for _, w := range ld.writers {
+		if err := w.Close(); err != nil {
+			log.Printf("[error] encrypt finish failed: %s", err.Error())
+		}
+	}
+	ld.writers = nil
+	ld.inner.Cleanup(ctx)
+}
+
+// SetMetadata sets the metadata to the inner log destination, if it is a MetadataSetter.
+func (ld *LogDestination) SetMetadata(m *ichigeki.Metadata) {
+	if setter, ok := ld.inner.(ichigeki.MetadataSetter); ok {
+		setter.SetMetadata(m)
+	}
+}
+
+func (ld *LogDestination) SetName(name string) {
+	ld.inner.SetName(name)
+}
+
+func (ld *LogDestination) String() string {
+	return ld.inner.String()
+}
+
+type readCloser struct {
+	*Reader
+	closer io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	return rc.closer.Close()
+}
@@ -0,0 +1,105 @@
+package encryptlog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/encryptlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogDestination(t *testing.T) {
+	restore := flextime.Set(time.Date(2022, 6, 5, 12, 0, 0, 0, time.Local))
+	defer restore()
+	tempDir := t.TempDir()
+	cfg := &encryptlog.Config{Passphrase: "correct horse battery staple", Iterations: 1000}
+	newHissatsu := func(scriptErr error) *ichigeki.Hissatsu {
+		ld, err := encryptlog.New(&ichigeki.LocalFile{Path: tempDir}, cfg)
+		require.NoError(t, err)
+		return &ichigeki.Hissatsu{
+			Name:           "test_run",
+			ExecDate:       time.Date(2022, 6, 5, 0, 0, 0, 0, time.Local),
+			LogDestination: ld,
+			Script: func(_ ichigeki.Context, stdout io.Writer, stderr io.Writer) error {
+				fmt.Fprintln(stdout, "user@example.com")
+				fmt.Fprintln(stderr, "warning")
+				return scriptErr
+			},
+			ConfirmDialog: ichigeki.Bool(false),
+			RerunPolicy:   ichigeki.RerunAllowAfterFailure,
+		}
+	}
+	require.EqualError(t, newHissatsu(errors.New("oops")).Execute(), "oops")
+	logPath := filepath.Join(tempDir, "test_run.log")
+	bs, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(bs, []byte("user@example.com")))
+	require.False(t, bytes.Contains(bs, []byte("test_run")))
+
+	// the previous outcome is read through the decryption.
+	require.NoError(t, newHissatsu(nil).Execute())
+	require.FileExists(t, filepath.Join(tempDir, "test_run.failure-20220605T120000.log"))
+	err = newHissatsu(nil).Execute()
+	require.ErrorIs(t, err, ichigeki.ErrAlreadyExecuted)
+	require.Contains(t, err.Error(), "previous outcome: success")
+
+	ld, err := encryptlog.New(&ichigeki.LocalFile{Path: tempDir}, cfg)
+	require.NoError(t, err)
+	ld.SetName("test_run")
+	r, err := ld.NewReader(context.Background())
+	require.NoError(t, err)
+	defer r.Close()
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Contains(t, string(plain), "name: test_run\n")
+	require.Contains(t, string(plain), "---\nuser@example.com\nwarning\n")
+	require.Contains(t, string(plain), "outcome: success\n")
+
+	entries, err := ld.List(context.Background(), "test_run")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	tempDir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 32)
+	cases := map[string][]byte{
+		"raw":    key,
+		"hex":    []byte(strings.Repeat("42", 32) + "\n"),
+		"base64": []byte("QkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkI=\n"),
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name)
+			require.NoError(t, os.WriteFile(path, content, 0600))
+			loaded, err := encryptlog.LoadKeyFile(path)
+			require.NoError(t, err)
+			require.Equal(t, key, loaded)
+		})
+	}
+	path := filepath.Join(tempDir, "short")
+	require.NoError(t, os.WriteFile(path, []byte("QkJC\n"), 0600))
+	_, err := encryptlog.LoadKeyFile(path)
+	require.EqualError(t, err, path+": key file must contain a 256-bit key (32 bytes, or encoded in hex or base64)")
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := encryptlog.New(&ichigeki.LocalFile{}, &encryptlog.Config{})
+	require.EqualError(t, err, "key or passphrase is required")
+	_, err = encryptlog.New(&ichigeki.LocalFile{}, &encryptlog.Config{Key: []byte("short")})
+	require.EqualError(t, err, "key must be 32 bytes, but 5 bytes")
+	_, err = encryptlog.New(&ichigeki.LocalFile{}, &encryptlog.Config{Key: make([]byte, 32), Passphrase: "secret"})
+	require.EqualError(t, err, "key and passphrase are exclusive")
+	_, err = encryptlog.New(&ichigeki.LocalFile{}, &encryptlog.Config{Key: make([]byte, 32), Compression: ichigeki.Compression(9)})
+	require.EqualError(t, err, "invalid compression 9")
+}
@@ -0,0 +1,427 @@
+package encryptlog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/mashiike/ichigeki"
+)
+
+// The encrypted log is the header and the frames:
+//
+//	header: magic "ICHIGEKIENC" | version 0x01 | compression (0x00 none, 0x01 gzip) | kdf (0x00 key, 0x01 pbkdf2) | [iterations uint32 | salt 16 bytes] | nonce prefix 7 bytes
+//	frame:  length of the sealed chunk uint32 | the chunk sealed by AES-256-GCM
+//
+// The plaintext is compressed before it is split into the chunks.
+// The nonce of a frame is the nonce prefix, the frame counter uint32 and 0x01 for the last frame, otherwise 0x00.
+// The header is the additional data of every frame, so that the frames can not be reordered, dropped or moved to another log.
+const (
+	magic   = "ICHIGEKIENC"
+	version = 0x01
+
+	kdfKey    = 0x00
+	kdfPBKDF2 = 0x01
+
+	saltSize        = 16
+	noncePrefixSize = 7
+	// chunkSize is the max size of the plaintext of a frame. The plaintext is buffered up to it.
+	chunkSize = 64 * 1024
+)
+
+var (
+	// ErrNotEncrypted is returned when the log does not start with the header of the encrypted log.
+	ErrNotEncrypted = errors.New("not an encrypted execution log")
+	// ErrAuthentication is returned when a frame can not be decrypted, because the key is wrong or the log is tampered.
+	ErrAuthentication = errors.New("message authentication failed (wrong key or tampered log)")
+)
+
+// Writer encrypts the written bytes to the underlying writer. It is safe for concurrent use.
+// Close must be called to write the last frame; a log without the last frame is read as truncated.
+type Writer struct {
+	mu sync.Mutex
+	// zw compresses the plaintext into the chunks.
+	zw      io.WriteCloser
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+	err     error
+}
+
+// NewWriter writes the header to w, and returns the writer to encrypt the log.
+func NewWriter(w io.Writer, cfg *Config) (*Writer, error) {
+	header := make([]byte, 0, len(magic)+3+4+saltSize+noncePrefixSize)
+	header = append(header, magic...)
+	header = append(header, version, byte(cfg.Compression))
+	key := cfg.Key
+	if cfg.Passphrase != "" {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		iterations := cfg.iterations()
+		key = pbkdf2(sha256.New, []byte(cfg.Passphrase), salt, iterations, keySize)
+		header = append(header, kdfPBKDF2)
+		header = appendUint32(header, uint32(iterations))
+		header = append(header, salt...)
+	} else {
+		header = append(header, kdfKey)
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header = append(header, prefix...)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	ew := &Writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+	}
+	ew.zw = cfg.Compression.NewWriter(writerFunc(ew.write))
+	return ew, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("write to closed writer")
+	}
+	return w.zw.Write(p)
+}
+
+// write buffers the compressed plaintext, and seals the full chunks.
+func (w *Writer) write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		l := chunkSize - len(w.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the buffered plaintext as the last frame. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.zw.Close(); err != nil {
+		return err
+	}
+	return w.seal(true)
+}
+
+func (w *Writer) seal(last bool) error {
+	if w.counter == ^uint32(0) {
+		w.err = errors.New("too many frames")
+		return w.err
+	}
+	frame := make([]byte, 4, 4+len(w.buf)+w.aead.Overhead())
+	frame = w.aead.Seal(frame, nonce(w.prefix, w.counter, last), w.buf, w.header)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	w.counter++
+	w.buf = w.buf[:0]
+	if _, err := w.w.Write(frame); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Reader decrypts the encrypted log.
+// The log of a crashed execution, which has no last frame, is read as far as it is written, and then Truncated reports true.
+type Reader struct {
+	r   io.Reader
+	cfg *Config
+	// plaintext decompresses the frames, nil until the header is read.
+	plaintext   io.Reader
+	compression ichigeki.Compression
+	aead        cipher.AEAD
+	header      []byte
+	prefix      []byte
+	counter     uint32
+	buf         []byte
+	frame       []byte
+	plain       []byte
+	done        bool
+	truncated   bool
+	err         error
+}
+
+// NewReader returns the reader to decrypt r. The header is read at the first Read.
+func NewReader(r io.Reader, cfg *Config) *Reader {
+	return &Reader{
+		r:   r,
+		cfg: cfg,
+	}
+}
+
+// Truncated reports whether the log ended without the last frame. It is valid after Read returns io.EOF.
+func (r *Reader) Truncated() bool {
+	return r.truncated
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.plaintext == nil {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.readHeader(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.err = err
+				return 0, err
+			}
+			// an empty log is claimed, but not written yet.
+			r.truncated = true
+			r.plaintext = bytes.NewReader(nil)
+			return 0, io.EOF
+		}
+		rc, err := r.compression.NewReader(io.NopCloser(readerFunc(r.readFrames)))
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.plaintext = rc
+	}
+	return r.plaintext.Read(p)
+}
+
+// readFrames reads the decrypted frames, which are compressed if the header says so.
+func (r *Reader) readFrames(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.open()
+		if errors.Is(r.err, io.EOF) {
+			r.err = nil
+			r.done = true
+			r.truncated = true
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) readHeader() error {
+	header := make([]byte, len(magic)+3)
+	if n, err := io.ReadFull(r.r, header); err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		if bytes.HasPrefix(header[:n], []byte(magic)) || bytes.HasPrefix([]byte(magic), header[:n]) {
+			return io.EOF
+		}
+		return ErrNotEncrypted
+	}
+	if string(header[:len(magic)]) != magic {
+		return ErrNotEncrypted
+	}
+	if header[len(magic)] != version {
+		return fmt.Errorf("unsupported version %d", header[len(magic)])
+	}
+	r.compression = ichigeki.Compression(header[len(magic)+1])
+	if !knownCompression(r.compression) {
+		return fmt.Errorf("unsupported compression %d", header[len(magic)+1])
+	}
+	kdf := header[len(magic)+2]
+	key := r.cfg.Key
+	switch kdf {
+	case kdfKey:
+		if r.cfg.Key == nil {
+			return errors.New("the log is encrypted with a key, but no key is given")
+		}
+	case kdfPBKDF2:
+		if r.cfg.Passphrase == "" {
+			return errors.New("the log is encrypted with a passphrase, but no passphrase is given")
+		}
+		params := make([]byte, 4+saltSize)
+		if err := readFull(r.r, params); err != nil {
+			return err
+		}
+		header = append(header, params...)
+		iterations := binary.BigEndian.Uint32(params)
+		if iterations == 0 || iterations > maxIterations {
+			return fmt.Errorf("invalid iterations %d", iterations)
+		}
+		key = pbkdf2(sha256.New, []byte(r.cfg.Passphrase), params[4:], int(iterations), keySize)
+	default:
+		return fmt.Errorf("unsupported kdf %d", kdf)
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if err := readFull(r.r, prefix); err != nil {
+		return err
+	}
+	header = append(header, prefix...)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	r.aead = aead
+	r.header = header
+	r.prefix = prefix
+	return nil
+}
+
+func (r *Reader) open() error {
+	var length [4]byte
+	if err := readFull(r.r, length[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(length[:]))
+	if size < r.aead.Overhead() || size > chunkSize+r.aead.Overhead() {
+		return fmt.Errorf("invalid frame length %d", size)
+	}
+	if cap(r.frame) < size {
+		r.frame = make([]byte, size)
+	}
+	sealed := r.frame[:size]
+	if err := readFull(r.r, sealed); err != nil {
+		return err
+	}
+	// not in place, because the failed Open clears the output and the frame is tried again as the last frame.
+	plain, err := r.aead.Open(r.plain[:0], nonce(r.prefix, r.counter, false), sealed, r.header)
+	if err != nil {
+		if plain, err = r.aead.Open(r.plain[:0], nonce(r.prefix, r.counter, true), sealed, r.header); err != nil {
+			return ErrAuthentication
+		}
+		r.done = true
+		var trailing [1]byte
+		if n, _ := io.ReadFull(r.r, trailing[:]); n > 0 {
+			return errors.New("unexpected data after the last frame")
+		}
+	}
+	r.counter++
+	r.plain = plain
+	r.buf = plain
+	return nil
+}
+
+// readFull returns io.EOF if the log ends in the middle, because the log of a crashed execution may be cut anywhere.
+func readFull(r io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		return err
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, but %d bytes", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 0, noncePrefixSize+5)
+	n = append(n, prefix...)
+	n = appendUint32(n, counter)
+	if last {
+		return append(n, 0x01)
+	}
+	return append(n, 0x00)
+}
+
+func knownCompression(c ichigeki.Compression) bool {
+	for _, known := range ichigeki.Compressions {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// pbkdf2 derives the key by PBKDF2 (RFC 8018).
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	blocks := (keyLen + size - 1) / size
+	dk := make([]byte, 0, blocks*size)
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(appendUint32(nil, uint32(block)))
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-size:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
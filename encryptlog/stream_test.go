@@ -0,0 +1,161 @@
+package encryptlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, keySize)
+
+func encrypt(t *testing.T, cfg *Config, plain []byte, close bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, cfg)
+	require.NoError(t, err)
+	// written in pieces, as the script writes the log.
+	for len(plain) > 0 {
+		n := 1000
+		if n > len(plain) {
+			n = len(plain)
+		}
+		_, err := w.Write(plain[:n])
+		require.NoError(t, err)
+		plain = plain[n:]
+	}
+	if close {
+		require.NoError(t, w.Close())
+	}
+	return buf.Bytes()
+}
+
+func decrypt(cfg *Config, encrypted []byte) ([]byte, bool, error) {
+	r := NewReader(bytes.NewReader(encrypted), cfg)
+	plain, err := io.ReadAll(r)
+	return plain, r.Truncated(), err
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := map[string]int{
+		"empty":           0,
+		"small":           100,
+		"just chunk":      chunkSize,
+		"multiple chunks": 3*chunkSize + 5,
+	}
+	for name, size := range cases {
+		t.Run(name, func(t *testing.T) {
+			plain := []byte(strings.Repeat("0123456789abcdef\n", size/17+1)[:size])
+			encrypted := encrypt(t, &Config{Key: testKey}, plain, true)
+			require.False(t, bytes.Contains(encrypted, []byte("0123456789abcdef")))
+			decrypted, truncated, err := decrypt(&Config{Key: testKey}, encrypted)
+			require.NoError(t, err)
+			require.False(t, truncated)
+			require.Equal(t, plain, append([]byte{}, decrypted...))
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	plain := []byte(strings.Repeat("0123456789abcdef\n", chunkSize))
+	cfg := &Config{Key: testKey, Compression: ichigeki.CompressionGzip}
+	encrypted := encrypt(t, cfg, plain, true)
+	require.Less(t, len(encrypted), len(plain)/10, "the plaintext is not compressed")
+	// the compression is read from the header.
+	decrypted, truncated, err := decrypt(&Config{Key: testKey}, encrypted)
+	require.NoError(t, err)
+	require.False(t, truncated)
+	require.Equal(t, plain, decrypted)
+
+	// the execution crashed before Close, so the compressed data is cut.
+	random := make([]byte, 2*chunkSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	plain = []byte(hex.EncodeToString(random))
+	encrypted = encrypt(t, cfg, plain, false)
+	decrypted, truncated, err = decrypt(&Config{Key: testKey}, encrypted)
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.NotEmpty(t, decrypted)
+	require.Equal(t, plain[:len(decrypted)], decrypted)
+}
+
+func TestPassphrase(t *testing.T) {
+	cfg := &Config{Passphrase: "correct horse battery staple", Iterations: 1000}
+	encrypted := encrypt(t, cfg, []byte("run!\n"), true)
+	// the iterations are read from the header.
+	decrypted, _, err := decrypt(&Config{Passphrase: "correct horse battery staple"}, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "run!\n", string(decrypted))
+
+	_, _, err = decrypt(&Config{Passphrase: "wrong", Iterations: 1000}, encrypted)
+	require.ErrorIs(t, err, ErrAuthentication)
+	_, _, err = decrypt(&Config{Key: testKey}, encrypted)
+	require.EqualError(t, err, "the log is encrypted with a passphrase, but no passphrase is given")
+}
+
+func TestWrongKey(t *testing.T) {
+	encrypted := encrypt(t, &Config{Key: testKey}, []byte("run!\n"), true)
+	_, _, err := decrypt(&Config{Key: bytes.Repeat([]byte{0x43}, keySize)}, encrypted)
+	require.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestTruncated(t *testing.T) {
+	plain := bytes.Repeat([]byte("x"), 2*chunkSize+10)
+	// the execution crashed before Close.
+	encrypted := encrypt(t, &Config{Key: testKey}, plain, false)
+	decrypted, truncated, err := decrypt(&Config{Key: testKey}, encrypted)
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.Equal(t, plain[:2*chunkSize], decrypted)
+
+	// cut in the middle of a frame.
+	decrypted, truncated, err = decrypt(&Config{Key: testKey}, encrypted[:len(encrypted)-100])
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.Equal(t, plain[:chunkSize], decrypted)
+
+	// claimed, but not written yet.
+	decrypted, truncated, err = decrypt(&Config{Key: testKey}, nil)
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.Empty(t, decrypted)
+}
+
+func TestTampered(t *testing.T) {
+	encrypted := encrypt(t, &Config{Key: testKey}, bytes.Repeat([]byte("x"), chunkSize+10), true)
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-20] ^= 0x01
+	_, _, err := decrypt(&Config{Key: testKey}, tampered)
+	require.ErrorIs(t, err, ErrAuthentication)
+
+	// the last frame is removed, so that the non-last frame becomes the end.
+	frameLen := 4 + chunkSize + 16
+	headerLen := len(encrypted) - frameLen - (4 + 10 + 16)
+	_, truncated, err := decrypt(&Config{Key: testKey}, encrypted[:headerLen+frameLen])
+	require.NoError(t, err)
+	require.True(t, truncated)
+
+	_, _, err = decrypt(&Config{Key: testKey}, append(append([]byte{}, encrypted...), 'x'))
+	require.EqualError(t, err, "unexpected data after the last frame")
+}
+
+func TestNotEncrypted(t *testing.T) {
+	_, _, err := decrypt(&Config{Key: testKey}, []byte("# This log is generated by github.com/mashiike/ichigeki.Hissatsu\n"))
+	require.ErrorIs(t, err, ErrNotEncrypted)
+	_, _, err = decrypt(&Config{Key: testKey}, []byte("run!"))
+	require.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	require.Equal(t, expected, hex.EncodeToString(pbkdf2(sha256.New, []byte("passwd"), []byte("salt"), 1, 64)))
+	expected = "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"
+	require.Equal(t, expected, hex.EncodeToString(pbkdf2(sha256.New, []byte("Password"), []byte("NaCl"), 80000, 64)))
+}
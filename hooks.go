@@ -0,0 +1,117 @@
+package ichigeki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HookFunc is called at a point of the execution lifecycle.
+type HookFunc func(ctx Context) error
+
+// Hooks are callbacks fired during the execution, for notifications, metrics and audit trails.
+// The lifecycle is AfterValidate, AfterConfirm, AfterClaim, BeforeRun, AfterRun and AfterCleanup.
+//
+// An error of AfterValidate, AfterConfirm, AfterClaim or BeforeRun aborts the execution.
+// If BeforeRun fails, the script is not run and the failure is recorded in the execution log.
+// An error of AfterRun or AfterCleanup is only logged, because the script has already been run.
+type Hooks struct {
+	AfterValidate HookFunc
+	// AfterConfirm is called after the execution is approved by the Confirmer.
+	AfterConfirm HookFunc
+	// AfterClaim is called after the execution name is claimed. It is not called in dry-run mode.
+	AfterClaim HookFunc
+	BeforeRun  HookFunc
+	// AfterRun is called after the script with its error and duration. The message of the error is redacted by Redactor.
+	AfterRun     func(ctx Context, scriptErr error, duration time.Duration) error
+	AfterCleanup HookFunc
+	// OnError is called when the execution returns an error. The message of the error is redacted by Redactor.
+	OnError func(ctx Context, err error)
+}
+
+func (h *Hissatsu) hookContext(ctx context.Context) Context {
+	return Context{
+		Context:  ctx,
+		Name:     h.Name,
+		ExecDate: h.ExecDate.Format(dateFormant),
+		RunID:    h.RunID,
+		DryRun:   h.DryRun,
+	}
+}
+
+func (h *Hissatsu) fireHook(ctx context.Context, name string, hook HookFunc) error {
+	if hook == nil {
+		return nil
+	}
+	if err := hook(h.hookContext(ctx)); err != nil {
+		return withKind(ErrHook, fmt.Errorf("hook %s: %w", name, err))
+	}
+	return nil
+}
+
+// MergeHooks returns the hooks calling each of the hooks in order.
+// A hook that aborts the execution stops calling the rest.
+func MergeHooks(hooks ...Hooks) Hooks {
+	merged := Hooks{
+		AfterValidate: mergeHookFuncs(hooks, func(h Hooks) HookFunc { return h.AfterValidate }),
+		AfterConfirm:  mergeHookFuncs(hooks, func(h Hooks) HookFunc { return h.AfterConfirm }),
+		AfterClaim:    mergeHookFuncs(hooks, func(h Hooks) HookFunc { return h.AfterClaim }),
+		BeforeRun:     mergeHookFuncs(hooks, func(h Hooks) HookFunc { return h.BeforeRun }),
+		AfterCleanup:  mergeHookFuncs(hooks, func(h Hooks) HookFunc { return h.AfterCleanup }),
+	}
+	for _, h := range hooks {
+		if h.AfterRun != nil {
+			merged.AfterRun = func(ctx Context, scriptErr error, duration time.Duration) error {
+				var errs []string
+				for _, h := range hooks {
+					if h.AfterRun == nil {
+						continue
+					}
+					if err := h.AfterRun(ctx, scriptErr, duration); err != nil {
+						errs = append(errs, err.Error())
+					}
+				}
+				if len(errs) > 0 {
+					return errors.New(strings.Join(errs, "; "))
+				}
+				return nil
+			}
+			break
+		}
+	}
+	for _, h := range hooks {
+		if h.OnError != nil {
+			merged.OnError = func(ctx Context, err error) {
+				for _, h := range hooks {
+					if h.OnError != nil {
+						h.OnError(ctx, err)
+					}
+				}
+			}
+			break
+		}
+	}
+	return merged
+}
+
+func mergeHookFuncs(hooks []Hooks, get func(Hooks) HookFunc) HookFunc {
+	var funcs []HookFunc
+	for _, h := range hooks {
+		if f := get(h); f != nil {
+			funcs = append(funcs, f)
+		}
+	}
+	if len(funcs) == 0 {
+		return nil
+	}
+	return func(ctx Context) error {
+		for _, f := range funcs {
+			if err := f(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -11,15 +11,20 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki/logparse"
 )
 
 const (
 	dateFormant = "2006-01-02"
+	// dryRunNameSuffix is appended to the name of the execution log in dry-run mode, e.g. `name.dryrun.log`.
+	dryRunNameSuffix = ".dryrun"
 )
 
 type LogDestination interface {
@@ -30,10 +35,84 @@ type LogDestination interface {
 	Cleanup(ctx context.Context)
 }
 
+// Claimer is an optional interface of LogDestination.
+// Claim reserves the execution name atomically before the script runs.
+// If another execution has already reserved the name, Claim returns an error that wraps ErrAlreadyClaimed.
+type Claimer interface {
+	Claim(ctx context.Context) error
+}
+
+// Releaser is an optional interface of Claimer.
+// Release removes the empty execution log created by Claim, so that the name is not consumed when the execution does not start.
+// If the name is not claimed, Release does nothing.
+type Releaser interface {
+	Release(ctx context.Context) error
+}
+
+// Lister is an optional interface of LogDestination.
+// List enumerates the execution logs stored in the destination whose name starts with the prefix.
+type Lister interface {
+	List(ctx context.Context, namePrefix string) ([]LogEntry, error)
+}
+
+// MetadataSetter is an optional interface of LogDestination.
+// SetMetadata is called with the metadata of the execution before NewWriter, e.g. to tag the stored log with the name and the exec date.
+// The footer fields, e.g. Outcome, are filled in the same Metadata before Cleanup.
+type MetadataSetter interface {
+	SetMetadata(m *Metadata)
+}
+
+// LogEntry is an execution log found by Lister.
+type LogEntry struct {
+	Name         string
+	LastModified time.Time
+}
+
+// ErrAlreadyClaimed is returned by Claimer.Claim when the execution name has already been reserved.
+var ErrAlreadyClaimed = errors.New("already claimed by another execution")
+
+// The errors returned by Hissatsu.Execute wrap one of these errors when it refuses to execute, so that callers can tell it from a failure of the script.
+var (
+	// ErrAlreadyExecuted means the execution name has already been executed, or claimed by another execution.
+	ErrAlreadyExecuted = errors.New("already executed")
+	// ErrNotInExecutionWindow means the execution date is not today, or now is out of the execution window.
+	ErrNotInExecutionWindow = errors.New("not in the execution window")
+	// ErrLogDestination means the log destination can not be checked, claimed or written.
+	ErrLogDestination = errors.New("log destination error")
+	// ErrHook means a hook aborted the execution, e.g. before_command failed.
+	ErrHook = errors.New("hook error")
+)
+
+// kindError tags the error with one of the errors above, without changing the message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func withKind(kind error, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
 type Context struct {
 	context.Context
 	Name     string
 	ExecDate string
+	RunID    string
+	DryRun   bool
+	// Outcome is the outcome written in the log footer. It is set only for the AfterRun hook.
+	Outcome Outcome
 }
 
 type ScriptFunc func(ctx Context, stdout io.Writer, stderr io.Writer) error
@@ -45,13 +124,35 @@ type Hissatsu struct {
 	Args                []string
 	Description         string
 	ExecDate            time.Time
+	NotBefore           time.Time
+	NotAfter            time.Time
+	Location            *time.Location
 	ConfirmDialog       *bool
 	LogDestination      LogDestination
 	Script              ScriptFunc
 	DialogMessage       string
 	PromptInput         io.Reader
+	RerunPolicy         RerunPolicy
+	RunID               string
+	DryRun              bool
+	ConfirmMode         ConfirmMode
+	ConfirmPhrase       string
+	Confirmer           Confirmer
+	ConfirmTimeout      time.Duration
+	Hooks               Hooks
+	// Timeout is the maximum duration of Script. zero means no timeout.
+	Timeout   time.Duration
+	LogFormat LogFormat
+	Redactor  *Redactor
+	// DigestKey makes the digest in the footer HMAC-SHA256 with the key, instead of SHA-256,
+	// so that the log can not be edited and digested again without the key. See logparse.Verify.
+	DigestKey []byte
+	// RerunUnfinished treats the execution log without the footer as interrupted, so that RerunPolicy may allow a rerun.
+	// The execution that is still running has no footer yet, so set it only when the previous execution is known to be dead.
+	RerunUnfinished bool
 
 	inCompilation bool
+	confirmation  *Confirmation
 }
 
 func (h *Hissatsu) Validate() error {
@@ -61,10 +162,16 @@ func (h *Hissatsu) Validate() error {
 	if h.Args == nil {
 		h.Args = os.Args
 	}
+	if !h.NotBefore.IsZero() && !h.NotAfter.IsZero() && h.NotAfter.Before(h.NotBefore) {
+		return fmt.Errorf("NotAfter %s is before NotBefore %s", h.NotAfter.Format(time.RFC3339), h.NotBefore.Format(time.RFC3339))
+	}
 	if h.ExecDate.IsZero() {
-		h.ExecDate = flextime.Now().In(time.Local)
+		if !h.NotBefore.IsZero() {
+			h.ExecDate = h.NotBefore.In(h.location())
+		} else {
+			h.ExecDate = flextime.Now().In(h.location())
+		}
 	}
-	h.ExecDate.Local().Truncate(24 * time.Hour)
 	if h.Name == "" {
 		if len(h.Args) == 0 {
 			return errors.New("no arguments")
@@ -76,6 +183,13 @@ func (h *Hissatsu) Validate() error {
 			}
 		}
 	}
+	if h.RunID == "" {
+		runID, err := newRunID()
+		if err != nil {
+			return fmt.Errorf("generate run id: %w", err)
+		}
+		h.RunID = runID
+	}
 	if h.ConfirmDialog == nil {
 		h.ConfirmDialog = Bool(true)
 	}
@@ -91,12 +205,25 @@ func (h *Hissatsu) Validate() error {
 	if cnt := strings.Count(h.DialogMessage, "%s"); cnt != 1 {
 		return fmt.Errorf("DialogMessage must always contain one string format specifier %%s: string format specifier count is %d", cnt)
 	}
-	if h.PromptInput == nil {
-		h.PromptInput = os.Stdin
+	if h.Confirmer == nil && *h.ConfirmDialog && h.ConfirmMode == ConfirmTypePhrase && h.ConfirmPhrase == "" {
+		return errors.New("ConfirmPhrase is required for ConfirmTypePhrase")
+	}
+	if h.Redactor != nil {
+		if err := h.Redactor.compile(); err != nil {
+			return fmt.Errorf("Redactor: %w", err)
+		}
 	}
 	return nil
 }
 
+// location returns the time zone in which "today" and the log timestamps are evaluated. default is time.Local.
+func (h *Hissatsu) location() *time.Location {
+	if h.Location == nil {
+		return time.Local
+	}
+	return h.Location
+}
+
 func (h *Hissatsu) logger() *log.Logger {
 	if h.Logger == nil {
 		return log.Default()
@@ -149,7 +276,7 @@ func (h *Hissatsu) GenerateName() error {
 	data := map[string]interface{}{
 		"Name":     h.Name,
 		"ExecDate": h.ExecDate.Format(dateFormant),
-		"Today":    flextime.Now().In(time.Local).Format(dateFormant),
+		"Today":    flextime.Now().In(h.location()).Format(dateFormant),
 		"Args":     h.Args,
 	}
 	var buf bytes.Buffer
@@ -170,6 +297,9 @@ func (h *Hissatsu) Execute() error {
 func (h *Hissatsu) ExecuteWithContext(ctx context.Context) (err error) {
 	defer func() {
 		if err != nil {
+			if h.Hooks.OnError != nil {
+				h.Hooks.OnError(h.hookContext(ctx), h.Redactor.redactError(err))
+			}
 			return
 		}
 		if rec := recover(); rec != nil {
@@ -182,77 +312,240 @@ func (h *Hissatsu) ExecuteWithContext(ctx context.Context) (err error) {
 			}
 		}
 	}()
+	var previous *logparse.Log
 	if verr := h.Validate(); verr != nil {
 		err = fmt.Errorf("Hissatsu.Validate(): %w", verr)
 		return
 	}
-	today := flextime.Now().In(time.Local).Truncate(24 * time.Hour)
-	if h.ExecDate.Format(dateFormant) != today.Format(dateFormant) {
-		err = fmt.Errorf("exec_date: %s is not today! (today: %s)", h.ExecDate.Format(dateFormant), today.Format(dateFormant))
+	if err = h.fireHook(ctx, "AfterValidate", h.Hooks.AfterValidate); err != nil {
+		return
+	}
+	if werr := h.checkExecutionWindow(); werr != nil {
+		err = werr
 		return
 	}
 	if exists, checkErr := h.LogDestination.AlreadyExists(ctx); checkErr != nil {
-		err = fmt.Errorf("Can't execute! Execution log destination [%s] check failed: %w", h.LogDestination.String(), checkErr)
+		err = withKind(ErrLogDestination, fmt.Errorf("Can't execute! Execution log destination [%s] check failed: %w", h.LogDestination.String(), checkErr))
 		return
 	} else if exists {
-		err = fmt.Errorf("Can't execute! Execution log destination [%s] already exists", h.LogDestination.String())
-		return
+		if h.RerunPolicy == RerunNever {
+			err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] already exists", h.LogDestination.String()))
+			return
+		}
+		if _, ok := h.LogDestination.(Archiver); !ok {
+			err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] already exists, and it can not be archived for rerun", h.LogDestination.String()))
+			return
+		}
+		l, readErr := ReadMetadata(ctx, h.LogDestination)
+		if readErr != nil {
+			err = withKind(ErrLogDestination, fmt.Errorf("Can't execute! Execution log destination [%s] already exists, and the previous outcome can not be read: %w", h.LogDestination.String(), readErr))
+			return
+		}
+		outcome := l.Outcome
+		if l.Truncated && !h.RerunUnfinished {
+			err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] already exists without the footer, the previous execution may be still running", h.LogDestination.String()))
+			return
+		}
+		if !h.RerunPolicy.Allows(outcome) {
+			err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] already exists (previous outcome: %s, rerun policy: %s)", h.LogDestination.String(), outcome, h.RerunPolicy))
+			return
+		}
+		h.logger().Printf("[info] previous execution ended with `%s`, rerun is allowed by rerun policy `%s`\n", outcome, h.RerunPolicy)
+		previous = l
+	}
+	if h.DryRun {
+		h.LogDestination.SetName(h.Name + dryRunNameSuffix)
+		h.logger().Printf("[info] dry-run: execution name `%s` is not consumed\n", h.Name)
 	}
 
 	h.logger().Printf("[info] log output to `%s`\n", h.LogDestination.String())
-	if *h.ConfirmDialog {
-		fmt.Fprintf(os.Stderr, h.DialogMessage+" [y/n]:", h.Name)
-		reader := bufio.NewReader(h.PromptInput)
-		response, promptErr := reader.ReadString('\n')
-		if promptErr != nil {
-			err = fmt.Errorf("prompt error: %w", promptErr)
+	confirmation, confirmErr := h.confirmer().Confirm(ctx, &ConfirmRequest{
+		Name:           h.Name,
+		ExecDate:       h.ExecDate.Format(dateFormant),
+		RunID:          h.RunID,
+		DryRun:         h.DryRun,
+		LogDestination: h.LogDestination.String(),
+		Message:        fmt.Sprintf(h.DialogMessage, h.Name),
+	})
+	if confirmErr != nil {
+		err = confirmErr
+		return
+	}
+	h.confirmation = confirmation
+	if err = h.fireHook(ctx, "AfterConfirm", h.Hooks.AfterConfirm); err != nil {
+		return
+	}
+	if h.DryRun {
+		if previous != nil {
+			h.logger().Printf("[info] dry-run: previous execution log would be archived")
+		}
+		err = h.running(ctx)
+		return
+	}
+	if previous != nil {
+		suffix := fmt.Sprintf(".%s-%s", previous.Outcome, flextime.Now().In(h.location()).Format("20060102T150405"))
+		if archiveErr := h.LogDestination.(Archiver).Archive(ctx, suffix, verifyPrevious(previous)); archiveErr != nil {
+			if errors.Is(archiveErr, ErrLogChanged) {
+				err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] was rerun by another execution: %w", h.LogDestination.String(), archiveErr))
+			} else {
+				err = withKind(ErrLogDestination, fmt.Errorf("Can't execute! Execution log destination [%s] archive failed: %w", h.LogDestination.String(), archiveErr))
+			}
+			return
+		}
+		h.logger().Printf("[info] previous execution log archived with suffix `%s`\n", suffix)
+	}
+	if claimer, ok := h.LogDestination.(Claimer); ok {
+		if claimErr := claimer.Claim(ctx); claimErr != nil {
+			if errors.Is(claimErr, ErrAlreadyClaimed) {
+				err = withKind(ErrAlreadyExecuted, fmt.Errorf("Can't execute! Execution log destination [%s] was claimed by another execution", h.LogDestination.String()))
+			} else {
+				err = withKind(ErrLogDestination, fmt.Errorf("Can't execute! Execution log destination [%s] claim failed: %w", h.LogDestination.String(), claimErr))
+			}
 			return
 		}
-		response = strings.ToLower(strings.TrimSpace(response))
-		if response != "y" && response != "yes" {
-			err = errors.New("canceled.")
+	}
+	if err = h.fireHook(ctx, "AfterClaim", h.Hooks.AfterClaim); err != nil {
+		// the name is released, because the empty log would refuse every later execution as still running.
+		if releaser, ok := h.LogDestination.(Releaser); ok {
+			if releaseErr := releaser.Release(ctx); releaseErr != nil {
+				h.logger().Printf("[warn] execution name `%s` is claimed, but not executed, and the release failed: %s\n", h.Name, releaseErr)
+			}
 			return
 		}
+		h.logger().Printf("[warn] execution name `%s` is claimed, but not executed\n", h.Name)
+		return
 	}
 	err = h.running(ctx)
 	return
 }
 
+// checkExecutionWindow checks that now is within [NotBefore, NotAfter].
+// If neither is specified, ExecDate must be today.
+func (h *Hissatsu) checkExecutionWindow() error {
+	now := flextime.Now().In(h.location())
+	if h.NotBefore.IsZero() && h.NotAfter.IsZero() {
+		if h.ExecDate.Format(dateFormant) != now.Format(dateFormant) {
+			return withKind(ErrNotInExecutionWindow, fmt.Errorf("exec_date: %s is not today! (today: %s)", h.ExecDate.Format(dateFormant), now.Format(dateFormant)))
+		}
+		return nil
+	}
+	if !h.NotBefore.IsZero() && now.Before(h.NotBefore) {
+		return withKind(ErrNotInExecutionWindow, fmt.Errorf("execution window is not open yet! opens in %s (not before: %s)", h.NotBefore.Sub(now).Round(time.Second), h.NotBefore.In(h.location()).Format(time.RFC3339)))
+	}
+	if !h.NotAfter.IsZero() && now.After(h.NotAfter) {
+		return withKind(ErrNotInExecutionWindow, fmt.Errorf("execution window is already closed! closed %s ago (not after: %s)", now.Sub(h.NotAfter).Round(time.Second), h.NotAfter.In(h.location()).Format(time.RFC3339)))
+	}
+	return nil
+}
+
 func (h *Hissatsu) running(ctx context.Context) error {
+	meta := &Metadata{
+		Name:     h.Name,
+		Start:    flextime.Now().In(h.location()),
+		RunID:    h.RunID,
+		Args:     h.Redactor.redactStrings(h.Args),
+		ExecDate: h.ExecDate.Format(dateFormant),
+		Host:     hostname(),
+		User:     username(),
+		Version:  version(),
+		DryRun:   h.DryRun,
+	}
+	if h.confirmation != nil {
+		meta.Confirmer = h.confirmation.Confirmer
+		meta.ConfirmMode = h.confirmation.ConfirmMode
+		meta.ConfirmedBy = h.confirmation.ConfirmedBy
+	}
+	if h.LogFormat != LogFormatRaw {
+		meta.LogFormat = h.LogFormat.String()
+	}
+	if setter, ok := h.LogDestination.(MetadataSetter); ok {
+		setter.SetMetadata(meta)
+	}
 	stdout, stderr, newErr := h.LogDestination.NewWriter(ctx)
 	if newErr != nil {
-		return fmt.Errorf("Can't execute! Execution log destination [%s] initialize failed: %w", h.LogDestination.String(), newErr)
+		return withKind(ErrLogDestination, fmt.Errorf("Can't execute! Execution log destination [%s] initialize failed: %w", h.LogDestination.String(), newErr))
 	}
 	var w io.Writer
+	var digest *digestWriter
 	if stdout == stderr {
-		w = stdout
+		digest = newDigestWriter(stdout, h.DigestKey)
+		stdout, stderr = digest, digest
+		w = digest
 	} else {
+		h.logger().Println("[warn] the digest is not recorded, because stdout and stderr are written to the log destination separately")
 		w = io.MultiWriter(stdout, stderr)
 	}
 
 	var err error
-	fmt.Fprintln(w, "# This log is generated by github.com/mashiike/ichigeki.Hissatsu")
-	fmt.Fprintf(w, "name: %s\n", h.Name)
-	fmt.Fprintf(w, "start: %s\n", flextime.Now().In(time.Local).Format(time.RFC3339))
-	fmt.Fprint(w, "---\n")
+	writeLogHeader(w, meta)
+	encoders := []*lineEncoder{
+		newLineEncoder(stdout, h.LogFormat, h.Redactor, "[out]", h.location()),
+		newLineEncoder(stderr, h.LogFormat, h.Redactor, "[err]", h.location()),
+	}
+	scriptCtx, cancel := ctx, context.CancelFunc(func() {})
+	if h.Timeout > 0 {
+		scriptCtx, cancel = context.WithTimeout(ctx, h.Timeout)
+	}
+	defer cancel()
 	defer func() {
-		fmt.Fprint(w, "\n---\n")
-		fmt.Fprintf(w, "end: %s\n", flextime.Now().In(time.Local).Format(time.RFC3339))
+		rec := recover()
+		if rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+		meta.End = flextime.Now().In(h.location())
+		meta.Duration = meta.End.Sub(meta.Start)
+		meta.Outcome = OutcomeSuccess
+		meta.ExitCode = exitCode(err)
 		if err != nil {
-			fmt.Fprintf(w, "error: %s\n", err.Error())
+			meta.Outcome = OutcomeFailure
+			var interrupted *InterruptedError
+			if errors.As(err, &interrupted) {
+				meta.Outcome = OutcomeInterrupted
+				meta.Interrupted = signalName(interrupted.Signal)
+			} else if ctx.Err() != nil {
+				meta.Outcome = OutcomeInterrupted
+			} else if errors.Is(scriptCtx.Err(), context.DeadlineExceeded) {
+				meta.Outcome = OutcomeTimeout
+			}
+			meta.Error = h.Redactor.redactString(err.Error())
+		}
+		for _, enc := range encoders {
+			if flushErr := enc.Flush(); flushErr != nil {
+				h.logger().Printf("[warn] flush log: %s\n", flushErr)
+			}
+		}
+		writeLogFooter(w, meta)
+		if digest != nil {
+			meta.Digest = digest.Sum()
+			writeLogDigest(w, meta)
+		}
+		if h.Hooks.AfterRun != nil {
+			hookCtx := h.hookContext(ctx)
+			hookCtx.Outcome = meta.Outcome
+			if hookErr := h.Hooks.AfterRun(hookCtx, h.Redactor.redactError(err), meta.Duration); hookErr != nil {
+				h.logger().Printf("[warn] hook AfterRun: %s\n", hookErr)
+			}
 		}
 		h.LogDestination.Cleanup(ctx)
+		if hookErr := h.fireHook(ctx, "AfterCleanup", h.Hooks.AfterCleanup); hookErr != nil {
+			h.logger().Printf("[warn] %s\n", hookErr)
+		}
+		if rec != nil {
+			panic(rec)
+		}
 	}()
+	if err = h.fireHook(ctx, "BeforeRun", h.Hooks.BeforeRun); err != nil {
+		return err
+	}
 	err = h.Script(
-		Context{
-			Context:  ctx,
-			Name:     h.Name,
-			ExecDate: h.ExecDate.Format(dateFormant),
-		},
-		io.MultiWriter(stdout, os.Stdout),
-		io.MultiWriter(stderr, os.Stderr),
+		h.hookContext(scriptCtx),
+		io.MultiWriter(encoders[0], os.Stdout),
+		io.MultiWriter(encoders[1], os.Stderr),
 	)
 	if err != nil {
+		if ctx.Err() == nil && errors.Is(scriptCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timeout after %s: %w", h.Timeout, err)
+		}
 		return err
 	}
 	fmt.Fprintln(os.Stderr, "")
@@ -267,27 +560,216 @@ func Bool(b bool) *bool {
 type LocalFile struct {
 	Path           string
 	LogFilePostfix string
-	name           string
-	fp             *os.File
-	writer         *bufio.Writer
+	// Compression compresses the log file, e.g. CompressionGzip writes `name.log.gz`.
+	Compression Compression
+	name        string
+	fp          *os.File
+	writer      *bufio.Writer
+	compressor  io.WriteCloser
 }
 
+// AlreadyExists checks the log file in any compression, so that changing Compression never allows a second execution.
 func (f *LocalFile) AlreadyExists(_ context.Context) (bool, error) {
-	_, err := os.Stat(f.String())
-	return err == nil, nil
+	for _, c := range Compressions {
+		if _, err := os.Stat(f.filename(c)); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+
+}
+
+// Claim creates the log file exclusively, so that only one execution can reserve the name.
+func (f *LocalFile) Claim(_ context.Context) error {
+	fp, err := os.OpenFile(f.String(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s: %w", f.String(), ErrAlreadyClaimed)
+		}
+		return err
+	}
+	for _, c := range f.Compression.SearchOrder()[1:] {
+		if _, err := os.Stat(f.filename(c)); err == nil {
+			fp.Close()
+			os.Remove(f.String())
+			return fmt.Errorf("%s: %w", f.filename(c), ErrAlreadyClaimed)
+		}
+	}
+	f.fp = fp
+	return nil
+}
+
+// Release removes the empty log file created by Claim.
+func (f *LocalFile) Release(_ context.Context) error {
+	if f.fp == nil || f.writer != nil {
+		return nil
+	}
+	f.fp.Close()
+	f.fp = nil
+	return os.Remove(f.String())
+}
+
+// NewReader opens the log file in the configured compression, or else in any other, and decompresses it.
+func (f *LocalFile) NewReader(_ context.Context) (io.ReadCloser, error) {
+	var notExist error
+	for _, c := range f.Compression.SearchOrder() {
+		fp, err := os.Open(f.filename(c))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if notExist == nil {
+					notExist = err
+				}
+				continue
+			}
+			return nil, err
+		}
+		return c.NewReader(fp)
+	}
+	return nil, notExist
+}
+
+// ReadMetadata reads the header and the footer of the execution log. The compressed log is read in full, because it can not be read from the end.
+func (f *LocalFile) ReadMetadata(_ context.Context) (*logparse.Log, error) {
+	var notExist error
+	for _, c := range f.Compression.SearchOrder() {
+		fp, err := os.Open(f.filename(c))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if notExist == nil {
+					notExist = err
+				}
+				continue
+			}
+			return nil, err
+		}
+		if c != CompressionNone {
+			r, err := c.NewReader(fp)
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return logparse.ParseMetadata(r)
+		}
+		defer fp.Close()
+		info, err := fp.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return logparse.ParseMetadataAt(fp, info.Size())
+	}
+	return nil, notExist
+}
+
+// Archive renames the execution log, and then verifies the renamed one, because another execution may rerun the name after it was read.
+// If the verification fails, the log is renamed back.
+func (f *LocalFile) Archive(_ context.Context, suffix string, verify func(r io.Reader) error) error {
+	for _, c := range f.Compression.SearchOrder() {
+		if _, err := os.Stat(f.filename(c)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		archived := filepath.Join(f.path(), f.name+suffix+f.logFilePostfix()+c.Extension())
+		if _, err := os.Stat(archived); err == nil {
+			return fmt.Errorf("%s already exists", archived)
+		}
+		if err := os.Rename(f.filename(c), archived); err != nil {
+			return err
+		}
+		if err := verifyFile(archived, c, verify); err != nil {
+			// linked, not renamed, so that the log claimed by another execution in the meantime is never overwritten.
+			if lerr := os.Link(archived, f.filename(c)); lerr != nil {
+				return fmt.Errorf("%w, and it can not be renamed back from %s: %s", err, archived, lerr)
+			}
+			os.Remove(archived)
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyFile(path string, c Compression, verify func(r io.Reader) error) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r, err := c.NewReader(fp)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return verify(r)
+}
 
+// List scans the directory for the files with LogFilePostfix, compressed or not.
+func (f *LocalFile) List(_ context.Context, namePrefix string) ([]LogEntry, error) {
+	entries, err := os.ReadDir(f.path())
+	if err != nil {
+		return nil, err
+	}
+	postfix := f.logFilePostfix()
+	logs := make([]LogEntry, 0, len(entries))
+	index := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), namePrefix) {
+			continue
+		}
+		name, ok := "", false
+		for _, c := range Compressions {
+			if strings.HasSuffix(entry.Name(), postfix+c.Extension()) {
+				name, ok = strings.TrimSuffix(entry.Name(), postfix+c.Extension()), true
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[name]; ok {
+			if info.ModTime().After(logs[i].LastModified) {
+				logs[i].LastModified = info.ModTime()
+			}
+			continue
+		}
+		index[name] = len(logs)
+		logs = append(logs, LogEntry{
+			Name:         name,
+			LastModified: info.ModTime(),
+		})
+	}
+	return logs, nil
 }
+
 func (f *LocalFile) NewWriter(ctx context.Context) (io.Writer, io.Writer, error) {
-	var err error
-	f.fp, err = os.Create(f.String())
+	if f.fp == nil {
+		var err error
+		f.fp, err = os.Create(f.String())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 	f.writer = bufio.NewWriter(f.fp)
-	return f.writer, f.writer, err
+	f.compressor = f.Compression.NewWriter(f.writer)
+	w := newSyncWriter(&sync.Mutex{}, f.compressor)
+	return w, w, nil
 }
 
 func (f *LocalFile) Cleanup(ctx context.Context) {
-	if f.fp != nil {
+	if f.compressor != nil {
+		f.compressor.Close()
+		f.compressor = nil
+	}
+	if f.writer != nil {
 		f.writer.Flush()
+		f.writer = nil
+	}
+	if f.fp != nil {
 		f.fp.Close()
+		f.fp = nil
 	}
 }
 
@@ -306,12 +788,16 @@ func (f *LocalFile) path() string {
 	return f.Path
 }
 
+func (f *LocalFile) filename(c Compression) string {
+	return filepath.Join(f.path(), f.name+f.logFilePostfix()+c.Extension())
+}
+
 func (f *LocalFile) SetName(name string) {
 	f.name = name
 }
 
 func (f *LocalFile) String() string {
-	return filepath.Join(f.path(), f.name+f.logFilePostfix())
+	return f.filename(f.Compression)
 }
 
 type MultipleLogDestination []LogDestination
@@ -329,6 +815,124 @@ func (mld MultipleLogDestination) AlreadyExists(ctx context.Context) (bool, erro
 	}
 	return false, nil
 }
+
+// Claim claims the name in every destination. If any of them fails, the destinations claimed so far are released.
+func (mld MultipleLogDestination) Claim(ctx context.Context) error {
+	for i, ld := range mld {
+		claimer, ok := ld.(Claimer)
+		if !ok {
+			continue
+		}
+		if err := claimer.Claim(ctx); err != nil {
+			if releaseErr := mld[:i].Release(ctx); releaseErr != nil {
+				log.Printf("[warn] release claimed log destinations: %s", releaseErr)
+			}
+			return fmt.Errorf("%s: %w", ld.String(), err)
+		}
+	}
+	return nil
+}
+
+// Release releases all destinations that implement Releaser, and returns the first error.
+func (mld MultipleLogDestination) Release(ctx context.Context) error {
+	var first error
+	for _, ld := range mld {
+		releaser, ok := ld.(Releaser)
+		if !ok {
+			continue
+		}
+		if err := releaser.Release(ctx); err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", ld.String(), err)
+		}
+	}
+	return first
+}
+
+// NewReader opens the execution log of the first readable destination that has it.
+func (mld MultipleLogDestination) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	for _, ld := range mld {
+		reader, ok := ld.(Reader)
+		if !ok {
+			continue
+		}
+		if exists, err := ld.AlreadyExists(ctx); err != nil {
+			return nil, fmt.Errorf("%s: %w", ld.String(), err)
+		} else if !exists {
+			continue
+		}
+		r, err := reader.NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ld.String(), err)
+		}
+		return r, nil
+	}
+	return nil, errors.New("execution log not found in any readable log destination")
+}
+
+// ReadMetadata reads the header and the footer of the execution log of the first readable destination that has it.
+func (mld MultipleLogDestination) ReadMetadata(ctx context.Context) (*logparse.Log, error) {
+	for _, ld := range mld {
+		_, isMetadataReader := ld.(MetadataReader)
+		_, isReader := ld.(Reader)
+		if !isMetadataReader && !isReader {
+			continue
+		}
+		if exists, err := ld.AlreadyExists(ctx); err != nil {
+			return nil, fmt.Errorf("%s: %w", ld.String(), err)
+		} else if !exists {
+			continue
+		}
+		l, err := ReadMetadata(ctx, ld)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ld.String(), err)
+		}
+		return l, nil
+	}
+	return nil, errors.New("execution log not found in any readable log destination")
+}
+
+// Archive archives the execution logs of all destinations. Every destination must implement Archiver.
+func (mld MultipleLogDestination) Archive(ctx context.Context, suffix string, verify func(r io.Reader) error) error {
+	for _, ld := range mld {
+		archiver, ok := ld.(Archiver)
+		if !ok {
+			return fmt.Errorf("%s: can not be archived", ld.String())
+		}
+		if err := archiver.Archive(ctx, suffix, verify); err != nil {
+			return fmt.Errorf("%s: %w", ld.String(), err)
+		}
+	}
+	return nil
+}
+
+// List merges the execution logs of all listable destinations, sorted by name.
+func (mld MultipleLogDestination) List(ctx context.Context, namePrefix string) ([]LogEntry, error) {
+	merged := make(map[string]LogEntry)
+	for _, ld := range mld {
+		lister, ok := ld.(Lister)
+		if !ok {
+			continue
+		}
+		entries, err := lister.List(ctx, namePrefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ld.String(), err)
+		}
+		for _, entry := range entries {
+			if current, ok := merged[entry.Name]; !ok || current.LastModified.Before(entry.LastModified) {
+				merged[entry.Name] = entry
+			}
+		}
+	}
+	logs := make([]LogEntry, 0, len(merged))
+	for _, entry := range merged {
+		logs = append(logs, entry)
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].Name < logs[j].Name
+	})
+	return logs, nil
+}
+
 func (mld MultipleLogDestination) NewWriter(ctx context.Context) (io.Writer, io.Writer, error) {
 	stdouts := make([]io.Writer, 0, len(mld))
 	stderrs := make([]io.Writer, 0, len(mld))
@@ -336,7 +940,7 @@ func (mld MultipleLogDestination) NewWriter(ctx context.Context) (io.Writer, io.
 	for _, ld := range mld {
 		stdout, stderr, err := ld.NewWriter(ctx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("%s:%d", ld.String(), err)
+			return nil, nil, fmt.Errorf("%s: %w", ld.String(), err)
 		}
 		if stdout == stderr {
 			stdouts = append(stdouts, stdout)
@@ -347,10 +951,12 @@ func (mld MultipleLogDestination) NewWriter(ctx context.Context) (io.Writer, io.
 			stderrs = append(stderrs, stderr)
 		}
 	}
+	// one lock for all, so that every log destination gets the writes in the same order.
+	mu := &sync.Mutex{}
 	if diff {
-		return io.MultiWriter(stdouts...), io.MultiWriter(stderrs...), nil
+		return newSyncWriter(mu, io.MultiWriter(stdouts...)), newSyncWriter(mu, io.MultiWriter(stderrs...)), nil
 	}
-	w := io.MultiWriter(stdouts...)
+	w := newSyncWriter(mu, io.MultiWriter(stdouts...))
 	return w, w, nil
 }
 
@@ -360,6 +966,15 @@ func (mld MultipleLogDestination) Cleanup(ctx context.Context) {
 	}
 }
 
+// SetMetadata sets the metadata to the destinations that implement MetadataSetter.
+func (mld MultipleLogDestination) SetMetadata(m *Metadata) {
+	for _, ld := range mld {
+		if setter, ok := ld.(MetadataSetter); ok {
+			setter.SetMetadata(m)
+		}
+	}
+}
+
 func (mld MultipleLogDestination) SetName(name string) {
 	for _, ld := range mld {
 		ld.SetName(name)
@@ -0,0 +1,421 @@
+// Package logparse reads back the execution logs written by github.com/mashiike/ichigeki.Hissatsu.
+package logparse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderComment is the first line of an execution log.
+const HeaderComment = "# This log is generated by github.com/mashiike/ichigeki.Hissatsu"
+
+// Delimiter separates the header, the body and the footer of an execution log.
+const Delimiter = "---"
+
+// ErrNotExecutionLog is returned when the log does not start with HeaderComment.
+var ErrNotExecutionLog = errors.New("not an ichigeki execution log")
+
+// Outcome is the recorded result of an execution, written in the log footer.
+type Outcome string
+
+const (
+	OutcomeSuccess     Outcome = "success"
+	OutcomeFailure     Outcome = "failure"
+	OutcomeInterrupted Outcome = "interrupted"
+	OutcomeTimeout     Outcome = "timeout"
+)
+
+// Metadata is the machine-readable record of an execution.
+// The header fields are written as YAML before the first `---` of the log, and the footer fields after the last `---`.
+type Metadata struct {
+	// header
+	Name        string    `json:"name"`
+	Start       time.Time `json:"start"`
+	RunID       string    `json:"run_id"`
+	Args        []string  `json:"args"`
+	ExecDate    string    `json:"exec_date"`
+	Host        string    `json:"host"`
+	User        string    `json:"user"`
+	Version     string    `json:"version"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+	Confirmer   string    `json:"confirmer,omitempty"`
+	ConfirmMode string    `json:"confirm_mode,omitempty"`
+	ConfirmedBy string    `json:"confirmed_by,omitempty"`
+	// LogFormat is the format of the body, e.g. `timestamped`. empty means raw.
+	LogFormat string `json:"log_format,omitempty"`
+
+	// footer
+	End      time.Time     `json:"end"`
+	Outcome  Outcome       `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Interrupted is the signal that interrupted the execution, e.g. `SIGTERM`.
+	Interrupted string `json:"interrupted,omitempty"`
+	// Digest is the digest of everything written before it, e.g. `sha256:<hex>`. It is the last line of the log. See Verify.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Log is a parsed execution log.
+type Log struct {
+	Metadata
+	// Body is the captured output of the script. It is empty when read by ParseMetadata.
+	Body []byte `json:"-"`
+	// Truncated is true when the log has no footer, e.g. the execution crashed.
+	// The Outcome of a truncated log is OutcomeInterrupted.
+	Truncated bool `json:"truncated"`
+}
+
+// Parse reads the whole execution log, including the body.
+func Parse(r io.Reader) (*Log, error) {
+	var body bytes.Buffer
+	l, err := parse(r, &body)
+	if err != nil {
+		return nil, err
+	}
+	l.Body = body.Bytes()
+	return l, nil
+}
+
+// ParseMetadata reads the execution log and discards the body, so that a large log never sits in memory.
+func ParseMetadata(r io.Reader) (*Log, error) {
+	return parse(r, io.Discard)
+}
+
+// ParseHeader reads only the header, and stops reading at the end of it.
+// It is for the log which can not be read from the end, e.g. compressed. The footer fields are left empty.
+func ParseHeader(r io.Reader) (*Log, error) {
+	l, complete, err := parseHeader(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		l.Truncated = true
+		l.Outcome = OutcomeInterrupted
+	}
+	return l, nil
+}
+
+// rangeSize is the size of the beginning and the end of the log read by ParseMetadataAt.
+const rangeSize = 64 * 1024
+
+// ParseMetadataAt reads the header from the beginning and the footer from the end of the log of the size,
+// so that the metadata of a large log is read without the body, e.g. by the ranged requests.
+// If the header or the footer does not fit in the range, it reads the whole log.
+func ParseMetadataAt(r io.ReaderAt, size int64) (*Log, error) {
+	if size > 2*rangeSize {
+		head := make([]byte, rangeSize)
+		if err := readAt(r, head, 0); err != nil {
+			return nil, err
+		}
+		tail := make([]byte, rangeSize)
+		if err := readAt(r, tail, size-rangeSize); err != nil {
+			return nil, err
+		}
+		if l, ok, err := parseHeadTail(head, tail); err != nil || ok {
+			return l, err
+		}
+	}
+	// the large buffer, so that the whole log is read in a few ranges.
+	return ParseMetadata(bufio.NewReaderSize(io.NewSectionReader(r, 0, size), 16*rangeSize))
+}
+
+func readAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	return err
+}
+
+// parseHeadTail parses the header in head and the footer in tail, which starts in the middle of a line.
+// ok is false if head ends in the header, or the footer may start before tail.
+func parseHeadTail(head, tail []byte) (l *Log, ok bool, err error) {
+	// head ends in the middle of a line as well.
+	head = head[:bytes.LastIndexByte(head, '\n')+1]
+	l, complete, err := parseHeader(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil || !complete {
+		return nil, false, err
+	}
+	i := bytes.IndexByte(tail, '\n')
+	if i < 0 {
+		return nil, false, nil
+	}
+	var pending []string
+	delimited, fields := false, true
+	for _, line := range strings.SplitAfter(string(tail[i+1:]), "\n") {
+		if line == "" {
+			continue
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case trimmed == Delimiter:
+			delimited = true
+			pending = []string{line}
+		case pending != nil && fieldPattern.MatchString(trimmed):
+			pending = append(pending, line)
+		default:
+			pending = nil
+			fields = fields && fieldPattern.MatchString(trimmed)
+		}
+	}
+	if !delimited && fields {
+		return nil, false, nil
+	}
+	footer := footerFields(pending)
+	if _, ok := footer["end"]; !ok {
+		l.Truncated = true
+		l.Outcome = OutcomeInterrupted
+		return l, true, nil
+	}
+	if err := l.setFooter(footer); err != nil {
+		return nil, false, err
+	}
+	return l, true, nil
+}
+
+var fieldPattern = regexp.MustCompile(`^([a-z_]+):(?: (.*))?$`)
+
+func parse(r io.Reader, body io.Writer) (*Log, error) {
+	br := bufio.NewReader(r)
+	l, complete, err := parseHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		l.Truncated = true
+		l.Outcome = OutcomeInterrupted
+		return l, nil
+	}
+
+	// body and footer: the footer is the `key: value` lines after the last `---`.
+	bw := &bodyWriter{w: body}
+	var pending []string
+	flush := func() error {
+		for _, line := range pending {
+			if _, err := io.WriteString(bw, line); err != nil {
+				return err
+			}
+		}
+		pending = nil
+		return nil
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line != "" {
+			trimmed := strings.TrimRight(line, "\r\n")
+			switch {
+			case trimmed == Delimiter:
+				if ferr := flush(); ferr != nil {
+					return nil, ferr
+				}
+				pending = append(pending, line)
+			case pending != nil && fieldPattern.MatchString(trimmed):
+				pending = append(pending, line)
+			default:
+				if ferr := flush(); ferr != nil {
+					return nil, ferr
+				}
+				if _, werr := io.WriteString(bw, line); werr != nil {
+					return nil, werr
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	footer := footerFields(pending)
+	if _, ok := footer["end"]; !ok {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		if err := bw.Close(); err != nil {
+			return nil, err
+		}
+		l.Truncated = true
+		l.Outcome = OutcomeInterrupted
+		return l, nil
+	}
+	if err := l.setFooter(footer); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseHeader reads the header up to the first `---`. complete is false if the log ends in the header.
+func parseHeader(br *bufio.Reader) (l *Log, complete bool, err error) {
+	l = &Log{}
+	first, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if first == "" {
+		return l, false, nil
+	}
+	if strings.TrimRight(first, "\r\n") != HeaderComment {
+		return nil, false, ErrNotExecutionLog
+	}
+	if err == io.EOF {
+		return l, false, nil
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == Delimiter {
+			return l, true, nil
+		}
+		if m := fieldPattern.FindStringSubmatch(trimmed); m != nil {
+			if err := l.setField(m[1], m[2]); err != nil {
+				return nil, false, err
+			}
+		}
+		if err == io.EOF {
+			return l, false, nil
+		}
+	}
+}
+
+// footerFields returns the fields of the footer, the `---` line and the `key: value` lines after it.
+func footerFields(pending []string) map[string]string {
+	footer := make(map[string]string, len(pending))
+	if len(pending) > 0 {
+		for _, line := range pending[1:] {
+			m := fieldPattern.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+			footer[m[1]] = m[2]
+		}
+	}
+	return footer
+}
+
+func (l *Log) setFooter(footer map[string]string) error {
+	for _, key := range []string{"end", "outcome", "duration", "exit_code", "error", "interrupted", "digest"} {
+		if value, ok := footer[key]; ok {
+			if err := l.setField(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	if l.Outcome == "" {
+		// logs written before outcome was recorded
+		l.Outcome = OutcomeSuccess
+		if _, ok := footer["error"]; ok {
+			l.Outcome = OutcomeFailure
+		}
+	}
+	return nil
+}
+
+func (l *Log) setField(key, value string) error {
+	var err error
+	switch key {
+	case "name":
+		l.Name = unquote(value)
+	case "start":
+		l.Start, err = time.Parse(time.RFC3339, value)
+	case "run_id":
+		l.RunID = unquote(value)
+	case "args":
+		err = json.Unmarshal([]byte(value), &l.Args)
+	case "exec_date":
+		l.ExecDate = unquote(value)
+	case "host":
+		l.Host = unquote(value)
+	case "user":
+		l.User = unquote(value)
+	case "version":
+		l.Version = unquote(value)
+	case "dry_run":
+		l.DryRun, err = strconv.ParseBool(value)
+	case "confirmer":
+		l.Confirmer = unquote(value)
+	case "confirm_mode":
+		l.ConfirmMode = unquote(value)
+	case "confirmed_by":
+		l.ConfirmedBy = unquote(value)
+	case "log_format":
+		l.LogFormat = unquote(value)
+	case "end":
+		l.End, err = time.Parse(time.RFC3339, value)
+	case "outcome":
+		l.Outcome = Outcome(unquote(value))
+	case "duration":
+		l.Duration, err = time.ParseDuration(value)
+	case "exit_code":
+		var code int
+		code, err = strconv.Atoi(value)
+		l.ExitCode = &code
+	case "error":
+		l.Error = unquote(value)
+	case "interrupted":
+		l.Interrupted = unquote(value)
+	case "digest":
+		l.Digest = unquote(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// unquote returns the value of a YAML scalar written by ichigeki: plain, or double quoted in JSON notation.
+func unquote(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		var str string
+		if err := json.Unmarshal([]byte(value), &str); err == nil {
+			return str
+		}
+	}
+	return value
+}
+
+// bodyWriter holds back the last newline, because the newline before the footer delimiter is written by ichigeki, not by the script.
+type bodyWriter struct {
+	w    io.Writer
+	held bool
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.held {
+		if _, err := w.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		w.held = false
+	}
+	if p[len(p)-1] == '\n' {
+		w.held = true
+		if _, err := w.w.Write(p[:len(p)-1]); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// Close writes the held newline, for the truncated log.
+func (w *bodyWriter) Close() error {
+	if w.held {
+		w.held = false
+		_, err := w.w.Write([]byte{'\n'})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package logparse_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mashiike/ichigeki/logparse"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func mustTime(str string) time.Time {
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name     string
+		log      string
+		expected *logparse.Log
+	}{
+		{
+			name: "success",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+run_id: "00000000-0000-4000-8000-000000000000"
+args: ["./migration.sh","--target","users table"]
+exec_date: "2022-06-05"
+host: ip-10-0-0-1
+user: ec2-user
+version: v0.3.0
+---
+run!
+---
+end: 2022-06-05T12:00:05+09:00
+outcome: success
+duration: 5s
+exit_code: 0
+`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:     "test_run",
+					Start:    mustTime("2022-06-05T12:00:00+09:00"),
+					RunID:    "00000000-0000-4000-8000-000000000000",
+					Args:     []string{"./migration.sh", "--target", "users table"},
+					ExecDate: "2022-06-05",
+					Host:     "ip-10-0-0-1",
+					User:     "ec2-user",
+					Version:  "v0.3.0",
+					End:      mustTime("2022-06-05T12:00:05+09:00"),
+					Outcome:  logparse.OutcomeSuccess,
+					Duration: 5 * time.Second,
+					ExitCode: intPtr(0),
+				},
+				Body: []byte("run!"),
+			},
+		},
+		{
+			name: "failure_with_delimiter_in_body",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+---
+---
+key: value
+---
+not footer
+---
+end: 2022-06-05T12:00:05+09:00
+outcome: failure
+duration: 5s
+exit_code: 2
+error: "command runtime error: exit status 2"
+`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:     "test_run",
+					Start:    mustTime("2022-06-05T12:00:00+09:00"),
+					End:      mustTime("2022-06-05T12:00:05+09:00"),
+					Outcome:  logparse.OutcomeFailure,
+					Duration: 5 * time.Second,
+					ExitCode: intPtr(2),
+					Error:    "command runtime error: exit status 2",
+				},
+				Body: []byte("---\nkey: value\n---\nnot footer"),
+			},
+		},
+		{
+			name: "interrupted",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+---
+processing 1
+---
+end: 2022-06-05T12:00:05+09:00
+outcome: interrupted
+duration: 5s
+error: "interrupted by SIGTERM: command runtime error: signal: terminated"
+interrupted: SIGTERM
+`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:        "test_run",
+					Start:       mustTime("2022-06-05T12:00:00+09:00"),
+					End:         mustTime("2022-06-05T12:00:05+09:00"),
+					Outcome:     logparse.OutcomeInterrupted,
+					Duration:    5 * time.Second,
+					Error:       "interrupted by SIGTERM: command runtime error: signal: terminated",
+					Interrupted: "SIGTERM",
+				},
+				Body: []byte("processing 1"),
+			},
+		},
+		{
+			name: "legacy",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+---
+run!
+---
+end: 2022-06-05T12:00:00+09:00
+error: command runtime error: exit status 1
+`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:    "test_run",
+					Start:   mustTime("2022-06-05T12:00:00+09:00"),
+					End:     mustTime("2022-06-05T12:00:00+09:00"),
+					Outcome: logparse.OutcomeFailure,
+					Error:   "command runtime error: exit status 1",
+				},
+				Body: []byte("run!"),
+			},
+		},
+		{
+			name: "truncated",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+---
+processing 1
+---
+processing 2
+`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:    "test_run",
+					Start:   mustTime("2022-06-05T12:00:00+09:00"),
+					Outcome: logparse.OutcomeInterrupted,
+				},
+				Body:      []byte("processing 1\n---\nprocessing 2\n"),
+				Truncated: true,
+			},
+		},
+		{
+			name: "truncated_header",
+			log: `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+star`,
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Name:    "test_run",
+					Outcome: logparse.OutcomeInterrupted,
+				},
+				Truncated: true,
+			},
+		},
+		{
+			name: "empty",
+			log:  "",
+			expected: &logparse.Log{
+				Metadata: logparse.Metadata{
+					Outcome: logparse.OutcomeInterrupted,
+				},
+				Truncated: true,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := logparse.Parse(strings.NewReader(c.log))
+			require.NoError(t, err)
+			require.EqualValues(t, string(c.expected.Body), string(actual.Body))
+			c.expected.Body, actual.Body = nil, nil
+			require.EqualValues(t, c.expected, actual)
+
+			meta, err := logparse.ParseMetadata(strings.NewReader(c.log))
+			require.NoError(t, err)
+			require.EqualValues(t, c.expected, meta)
+
+			meta, err = logparse.ParseMetadataAt(strings.NewReader(c.log), int64(len(c.log)))
+			require.NoError(t, err)
+			require.EqualValues(t, c.expected, meta)
+		})
+	}
+}
+
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.read += n
+	return n, err
+}
+
+func TestParseMetadataAt(t *testing.T) {
+	header := "# This log is generated by github.com/mashiike/ichigeki.Hissatsu\nname: test_run\nstart: 2022-06-05T12:00:00+09:00\n---\n"
+	body := strings.Repeat("processing\n", 30000)
+	footer := "---\nend: 2022-06-05T12:00:05+09:00\noutcome: failure\nduration: 5s\nexit_code: 2\nerror: \"command runtime error: exit status 2\"\n"
+	cases := []struct {
+		name string
+		log  string
+		// ranged is true if the header and the footer are read without the body.
+		ranged bool
+	}{
+		{name: "success", log: header + body + footer, ranged: true},
+		{name: "truncated", log: header + body, ranged: true},
+		{name: "delimiter_in_body", log: header + body + "---\nkey: value\n---\nnot footer\n" + footer, ranged: true},
+		{name: "large_header", log: strings.Replace(header, "---\n", "args: [\""+strings.Repeat("x", 70000)+"\"]\n---\n", 1) + body + footer},
+		{name: "large_footer", log: header + body + strings.Replace(footer, "exit status 2", strings.Repeat("x", 70000), 1)},
+		{name: "truncated_in_fields", log: header + body + strings.Repeat("key: value\n", 7000)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			expected, err := logparse.ParseMetadata(strings.NewReader(c.log))
+			require.NoError(t, err)
+			r := &countingReaderAt{r: strings.NewReader(c.log)}
+			actual, err := logparse.ParseMetadataAt(r, int64(len(c.log)))
+			require.NoError(t, err)
+			require.EqualValues(t, expected, actual)
+			if c.ranged {
+				require.Less(t, r.read, len(c.log)/2, "the body is read")
+			}
+		})
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	l, err := logparse.ParseHeader(strings.NewReader("# This log is generated by github.com/mashiike/ichigeki.Hissatsu\nname: test_run\n---\nend: not a time\n"))
+	require.NoError(t, err)
+	require.EqualValues(t, &logparse.Log{Metadata: logparse.Metadata{Name: "test_run"}}, l)
+
+	l, err = logparse.ParseHeader(strings.NewReader("# This log is generated by github.com/mashiike/ichigeki.Hissatsu\nname: test_run\n"))
+	require.NoError(t, err)
+	require.True(t, l.Truncated)
+}
+
+func TestParseNotExecutionLog(t *testing.T) {
+	_, err := logparse.Parse(strings.NewReader("hello world\n"))
+	require.ErrorIs(t, err, logparse.ErrNotExecutionLog)
+}
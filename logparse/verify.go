@@ -0,0 +1,149 @@
+package logparse
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// The algorithms of the digest, written before the hex of the digest, e.g. `sha256:<hex>`.
+const (
+	DigestSHA256     = "sha256"
+	DigestHMACSHA256 = "hmac-sha256"
+)
+
+var (
+	// ErrNoDigest is returned by Verify when the log has no digest, e.g. the execution crashed or the log was written by an older version.
+	ErrNoDigest = errors.New("no digest in the footer")
+	// ErrDigestMismatch is returned by Verify when the log was edited after it was written.
+	ErrDigestMismatch = errors.New("digest mismatch")
+)
+
+// Digest computes the digest of the execution log: SHA-256, or HMAC-SHA256 if the key is given.
+type Digest struct {
+	algorithm string
+	h         hash.Hash
+}
+
+func NewDigest(key []byte) *Digest {
+	if key != nil {
+		return &Digest{algorithm: DigestHMACSHA256, h: hmac.New(sha256.New, key)}
+	}
+	return &Digest{algorithm: DigestSHA256, h: sha256.New()}
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	return d.h.Write(p)
+}
+
+// String returns the digest of the bytes written so far, e.g. `sha256:<hex>`.
+func (d *Digest) String() string {
+	return d.algorithm + ":" + hex.EncodeToString(d.h.Sum(nil))
+}
+
+// maxDigestLineLength is longer than any digest line, e.g. `digest: hmac-sha256:<64 hex>`.
+const maxDigestLineLength = 256
+
+// Verify reads the whole execution log, and checks the digest in the last line against everything before it.
+// If the key is given, the digest must be HMAC-SHA256 with the key, because a plain SHA-256 can be recomputed by whoever edits the log.
+func Verify(r io.Reader, key []byte) (*Log, error) {
+	v := &digestVerifier{
+		sha256: NewDigest(nil),
+	}
+	if key != nil {
+		v.hmac = NewDigest(key)
+	}
+	tee := io.TeeReader(r, v)
+	l, err := ParseMetadata(tee)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, err
+	}
+	if l.Digest == "" {
+		return l, ErrNoDigest
+	}
+	var d *Digest
+	switch algorithm := strings.SplitN(l.Digest, ":", 2)[0]; algorithm {
+	case DigestSHA256:
+		if key != nil {
+			return l, fmt.Errorf("the digest is %s, but HMAC is expected with the key", DigestSHA256)
+		}
+		d = v.sha256
+	case DigestHMACSHA256:
+		if key == nil {
+			return l, fmt.Errorf("the digest is %s, but no key is given", DigestHMACSHA256)
+		}
+		d = v.hmac
+	default:
+		return l, fmt.Errorf("unknown digest algorithm `%s`", algorithm)
+	}
+	if v.last == nil || len(v.line) > 0 || string(v.last) != "digest: "+l.Digest+"\n" {
+		return l, fmt.Errorf("%w: the digest is not the last line", ErrDigestMismatch)
+	}
+	if !hmac.Equal([]byte(d.String()), []byte(l.Digest)) {
+		return l, ErrDigestMismatch
+	}
+	return l, nil
+}
+
+// digestVerifier digests everything but the last line, which is held back as the digest line.
+type digestVerifier struct {
+	sha256 *Digest
+	hmac   *Digest
+	// last is the last complete line, nil if it is too long to be the digest line.
+	last []byte
+	// line is the incomplete line.
+	line []byte
+	long bool
+}
+
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if v.last != nil {
+			v.digest(v.last)
+			v.last = nil
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			v.appendLine(p)
+			break
+		}
+		v.appendLine(p[:i+1])
+		p = p[i+1:]
+		if !v.long {
+			v.last = append([]byte{}, v.line...)
+		}
+		v.line = v.line[:0]
+		v.long = false
+	}
+	return n, nil
+}
+
+func (v *digestVerifier) appendLine(p []byte) {
+	if v.long {
+		v.digest(p)
+		return
+	}
+	v.line = append(v.line, p...)
+	if len(v.line) > maxDigestLineLength {
+		v.digest(v.line)
+		v.line = v.line[:0]
+		v.long = true
+	}
+}
+
+func (v *digestVerifier) digest(p []byte) {
+	v.sha256.Write(p)
+	if v.hmac != nil {
+		v.hmac.Write(p)
+	}
+}
@@ -0,0 +1,109 @@
+package logparse_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mashiike/ichigeki/logparse"
+	"github.com/stretchr/testify/require"
+)
+
+const unsignedLog = `# This log is generated by github.com/mashiike/ichigeki.Hissatsu
+name: test_run
+start: 2022-06-05T12:00:00+09:00
+---
+run!
+---
+end: 2022-06-05T12:00:05+09:00
+outcome: success
+duration: 5s
+exit_code: 0
+`
+
+func signLog(log string, key []byte) string {
+	d := logparse.NewDigest(key)
+	d.Write([]byte(log))
+	return log + "digest: " + d.String() + "\n"
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("s3cr3t")
+	longLine := strings.Repeat("x", 1000)
+	cases := []struct {
+		name     string
+		log      string
+		key      []byte
+		expected string
+		is       error
+	}{
+		{
+			name: "sha256",
+			log:  signLog(unsignedLog, nil),
+		},
+		{
+			name: "hmac",
+			log:  signLog(unsignedLog, key),
+			key:  key,
+		},
+		{
+			name: "long_line",
+			log:  signLog(strings.Replace(unsignedLog, "run!", longLine+"\n"+longLine, 1), key),
+			key:  key,
+		},
+		{
+			name:     "edited",
+			log:      strings.Replace(signLog(unsignedLog, key), "run!", "ran!", 1),
+			key:      key,
+			expected: "digest mismatch",
+			is:       logparse.ErrDigestMismatch,
+		},
+		{
+			name:     "wrong_key",
+			log:      signLog(unsignedLog, []byte("another")),
+			key:      key,
+			expected: "digest mismatch",
+			is:       logparse.ErrDigestMismatch,
+		},
+		{
+			name:     "appended",
+			log:      signLog(unsignedLog, key) + "exit_code: 1\n",
+			key:      key,
+			expected: "digest mismatch: the digest is not the last line",
+			is:       logparse.ErrDigestMismatch,
+		},
+		{
+			name:     "no_digest",
+			log:      unsignedLog,
+			expected: "no digest in the footer",
+			is:       logparse.ErrNoDigest,
+		},
+		{
+			name:     "sha256_with_key",
+			log:      signLog(unsignedLog, nil),
+			key:      key,
+			expected: "the digest is sha256, but HMAC is expected with the key",
+		},
+		{
+			name:     "hmac_without_key",
+			log:      signLog(unsignedLog, key),
+			expected: "the digest is hmac-sha256, but no key is given",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l, err := logparse.Verify(iotest.OneByteReader(strings.NewReader(c.log)), c.key)
+			if c.expected == "" {
+				require.NoError(t, err)
+				require.True(t, strings.HasSuffix(c.log, "digest: "+l.Digest+"\n"))
+				require.EqualValues(t, logparse.OutcomeSuccess, l.Outcome)
+				return
+			}
+			require.EqualError(t, err, c.expected)
+			if c.is != nil {
+				require.True(t, errors.Is(err, c.is))
+			}
+		})
+	}
+}
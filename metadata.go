@@ -0,0 +1,156 @@
+package ichigeki
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+// Version is the ichigeki version recorded in the execution log metadata.
+// If empty, the module version in the build info is used.
+var Version = ""
+
+// Metadata is the machine-readable record of an execution, read back by the logparse package.
+type Metadata = logparse.Metadata
+
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func username() string {
+	u, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+	return u.Username
+}
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == "github.com/mashiike/ichigeki" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/mashiike/ichigeki" {
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// exitCode returns the exit code of the error, if the error has one like *exec.ExitError.
+func exitCode(err error) *int {
+	if err == nil {
+		code := 0
+		return &code
+	}
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		if code := coder.ExitCode(); code >= 0 {
+			return &code
+		}
+	}
+	return nil
+}
+
+func writeLogHeader(w io.Writer, m *Metadata) {
+	fmt.Fprintln(w, logparse.HeaderComment)
+	fmt.Fprintf(w, "name: %s\n", yamlString(m.Name))
+	fmt.Fprintf(w, "start: %s\n", m.Start.Format(time.RFC3339))
+	fmt.Fprintf(w, "run_id: %s\n", yamlString(m.RunID))
+	fmt.Fprintf(w, "args: %s\n", yamlStrings(m.Args))
+	fmt.Fprintf(w, "exec_date: %s\n", yamlString(m.ExecDate))
+	fmt.Fprintf(w, "host: %s\n", yamlString(m.Host))
+	fmt.Fprintf(w, "user: %s\n", yamlString(m.User))
+	fmt.Fprintf(w, "version: %s\n", yamlString(m.Version))
+	if m.DryRun {
+		fmt.Fprintln(w, "dry_run: true")
+	}
+	if m.Confirmer != "" {
+		fmt.Fprintf(w, "confirmer: %s\n", yamlString(m.Confirmer))
+	}
+	if m.ConfirmMode != "" {
+		fmt.Fprintf(w, "confirm_mode: %s\n", yamlString(m.ConfirmMode))
+	}
+	if m.ConfirmedBy != "" {
+		fmt.Fprintf(w, "confirmed_by: %s\n", yamlString(m.ConfirmedBy))
+	}
+	if m.LogFormat != "" {
+		fmt.Fprintf(w, "log_format: %s\n", yamlString(m.LogFormat))
+	}
+	fmt.Fprintln(w, logparse.Delimiter)
+}
+
+func writeLogFooter(w io.Writer, m *Metadata) {
+	fmt.Fprintf(w, "\n%s\n", logparse.Delimiter)
+	fmt.Fprintf(w, "end: %s\n", m.End.Format(time.RFC3339))
+	fmt.Fprintf(w, "outcome: %s\n", m.Outcome)
+	fmt.Fprintf(w, "duration: %s\n", m.Duration)
+	if m.ExitCode != nil {
+		fmt.Fprintf(w, "exit_code: %d\n", *m.ExitCode)
+	}
+	if m.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", yamlString(m.Error))
+	}
+	if m.Interrupted != "" {
+		fmt.Fprintf(w, "interrupted: %s\n", yamlString(m.Interrupted))
+	}
+}
+
+// writeLogDigest writes the digest as the last line of the footer, because it covers everything written before it.
+func writeLogDigest(w io.Writer, m *Metadata) {
+	fmt.Fprintf(w, "digest: %s\n", m.Digest)
+}
+
+var yamlPlainPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./\-]*$`)
+
+// yamlString returns the string as a YAML scalar. It is written plain if it is unambiguous, otherwise double quoted in JSON notation.
+func yamlString(str string) string {
+	if yamlPlainPattern.MatchString(str) {
+		switch strings.ToLower(str) {
+		case "true", "false", "yes", "no", "on", "off", "null":
+		default:
+			return str
+		}
+	}
+	bs, _ := json.Marshal(str)
+	return string(bs)
+}
+
+func yamlStrings(strs []string) string {
+	if strs == nil {
+		strs = []string{}
+	}
+	bs, _ := json.Marshal(strs)
+	return string(bs)
+}
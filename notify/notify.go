@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mashiike/ichigeki"
+)
+
+// EventType is the point of the execution notified.
+type EventType string
+
+const (
+	EventStart     EventType = "start"
+	EventSuccess   EventType = "success"
+	EventFailure   EventType = "failure"
+	EventCancelled EventType = "cancelled"
+)
+
+func ParseEventType(str string) (EventType, error) {
+	switch e := EventType(str); e {
+	case EventStart, EventSuccess, EventFailure, EventCancelled:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown event `%s`", str)
+}
+
+// Event is what is notified, and the data of the payload template.
+type Event struct {
+	Type     EventType
+	Name     string
+	ExecDate string
+	RunID    string
+	DryRun   bool
+	Duration time.Duration
+	Error    string
+	// LogURI is the location of the execution log, e.g. `s3://bucket/logs/name.log`.
+	LogURI string
+}
+
+// Text is the human-readable summary of the event, used by the default payload.
+func (e *Event) Text() string {
+	name := "`" + e.Name + "`"
+	if e.DryRun {
+		name += " (dry-run)"
+	}
+	var text string
+	switch e.Type {
+	case EventStart:
+		text = fmt.Sprintf("[ichigeki] %s started", name)
+	case EventSuccess:
+		text = fmt.Sprintf("[ichigeki] %s succeeded in %s", name, e.Duration.Round(time.Millisecond))
+	case EventFailure:
+		text = fmt.Sprintf("[ichigeki] %s failed after %s: %s", name, e.Duration.Round(time.Millisecond), e.Error)
+	case EventCancelled:
+		text = fmt.Sprintf("[ichigeki] %s was cancelled", name)
+		if e.Error != "" {
+			text += ": " + e.Error
+		}
+	default:
+		text = fmt.Sprintf("[ichigeki] %s %s", name, e.Type)
+	}
+	return fmt.Sprintf("%s\nexec date: %s, run id: %s, log: %s", text, e.ExecDate, e.RunID, e.LogURI)
+}
+
+// Notifier sends the event to somewhere.
+type Notifier interface {
+	Notify(ctx context.Context, e *Event) error
+}
+
+// Hooks returns the hooks notifying the start, success, failure and cancellation of the execution.
+// The log destination is used for the LogURI of the event.
+// Notification errors are only logged, so that a notifier never breaks the execution.
+func Hooks(ld fmt.Stringer, notifiers ...Notifier) ichigeki.Hooks {
+	if len(notifiers) == 0 {
+		return ichigeki.Hooks{}
+	}
+	newEvent := func(ctx ichigeki.Context, t EventType) *Event {
+		return &Event{
+			Type:     t,
+			Name:     ctx.Name,
+			ExecDate: ctx.ExecDate,
+			RunID:    ctx.RunID,
+			DryRun:   ctx.DryRun,
+			LogURI:   ld.String(),
+		}
+	}
+	notify := func(e *Event) {
+		// notify even if the execution is interrupted.
+		ctx := context.Background()
+		for _, n := range notifiers {
+			if err := n.Notify(ctx, e); err != nil {
+				log.Printf("[warn] notify %s: %s", e.Type, err)
+			}
+		}
+	}
+	return ichigeki.Hooks{
+		BeforeRun: func(ctx ichigeki.Context) error {
+			notify(newEvent(ctx, EventStart))
+			return nil
+		},
+		AfterRun: func(ctx ichigeki.Context, scriptErr error, duration time.Duration) error {
+			var e *Event
+			switch {
+			case scriptErr == nil:
+				e = newEvent(ctx, EventSuccess)
+			case ctx.Err() != nil:
+				e = newEvent(ctx, EventCancelled)
+				e.Error = scriptErr.Error()
+			default:
+				e = newEvent(ctx, EventFailure)
+				e.Error = scriptErr.Error()
+			}
+			e.Duration = duration
+			notify(e)
+			return nil
+		},
+		OnError: func(ctx ichigeki.Context, err error) {
+			if errors.Is(err, ichigeki.ErrCanceled) {
+				e := newEvent(ctx, EventCancelled)
+				e.Error = err.Error()
+				notify(e)
+			}
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// DefaultWebhookTemplate is the payload compatible with the incoming webhooks of Slack and Mattermost.
+const DefaultWebhookTemplate = `{"text":{{ json .Text }}}`
+
+// Webhook posts the JSON payload rendered by Template to URL.
+type Webhook struct {
+	URL string
+	// Template is a text/template of the payload. The data is *Event, and `json` encodes a value as JSON.
+	// If empty, DefaultWebhookTemplate is used.
+	Template string
+	// Events to notify. If empty, all events are notified.
+	Events  []EventType
+	Headers map[string]string
+	// Client is the HTTP client. If nil, a client with 10 seconds timeout is used.
+	Client *http.Client
+
+	tmpl *template.Template
+}
+
+func (w *Webhook) Validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("webhook url is required")
+	}
+	text := w.Template
+	if text == "" {
+		text = DefaultWebhookTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			bs, err := json.Marshal(v)
+			return string(bs), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("webhook template: %w", err)
+	}
+	w.tmpl = tmpl
+	if w.Client == nil {
+		w.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, e *Event) error {
+	if !w.subscribes(e.Type) {
+		return nil
+	}
+	if w.tmpl == nil {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := w.tmpl.Execute(&buf, e); err != nil {
+		return fmt.Errorf("webhook template: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (w *Webhook) subscribes(t EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/notify"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+	headers  []http.Header
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.payloads = append(rec.payloads, payload)
+	rec.headers = append(rec.headers, r.Header)
+}
+
+func TestWebhook(t *testing.T) {
+	restore := flextime.Fix(time.Date(2022, 6, 5, 12, 0, 0, 0, time.Local))
+	defer restore()
+
+	cases := []struct {
+		name     string
+		webhook  notify.Webhook
+		script   error
+		input    string
+		expected []map[string]interface{}
+	}{
+		{
+			name:    "success",
+			webhook: notify.Webhook{},
+			input:   "y\n",
+			expected: []map[string]interface{}{
+				{"text": "[ichigeki] `test_run` started\nexec date: 2022-06-05, run id: run-1, log: <dir>/test_run.log"},
+				{"text": "[ichigeki] `test_run` succeeded in 0s\nexec date: 2022-06-05, run id: run-1, log: <dir>/test_run.log"},
+			},
+		},
+		{
+			name:    "failure",
+			webhook: notify.Webhook{Events: []notify.EventType{notify.EventFailure}},
+			script:  errors.New("exit status 1"),
+			input:   "y\n",
+			expected: []map[string]interface{}{
+				{"text": "[ichigeki] `test_run` failed after 0s: exit status 1\nexec date: 2022-06-05, run id: run-1, log: <dir>/test_run.log"},
+			},
+		},
+		{
+			name:    "cancelled",
+			webhook: notify.Webhook{},
+			input:   "n\n",
+			expected: []map[string]interface{}{
+				{"text": "[ichigeki] `test_run` was cancelled: canceled.\nexec date: 2022-06-05, run id: run-1, log: <dir>/test_run.log"},
+			},
+		},
+		{
+			name: "template",
+			webhook: notify.Webhook{
+				Template: `{"event":{{ json .Type }},"name":{{ json .Name }},"error":{{ json .Error }}}`,
+				Events:   []notify.EventType{notify.EventSuccess, notify.EventFailure},
+			},
+			script: errors.New("exit status 2"),
+			input:  "y\n",
+			expected: []map[string]interface{}{
+				{"event": "failure", "name": "test_run", "error": "exit status 2"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := &recorder{}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+			webhook := c.webhook
+			webhook.URL = server.URL
+			webhook.Headers = map[string]string{"X-Test": "ichigeki"}
+			require.NoError(t, webhook.Validate())
+
+			tempDir := t.TempDir()
+			ld := &ichigeki.LocalFile{Path: tempDir}
+			h := &ichigeki.Hissatsu{
+				Name:           "test_run",
+				RunID:          "run-1",
+				ExecDate:       time.Date(2022, 6, 5, 0, 0, 0, 0, time.Local),
+				LogDestination: ld,
+				PromptInput:    strings.NewReader(c.input),
+				Hooks:          notify.Hooks(ld, &webhook),
+				Script: func(_ ichigeki.Context, _ io.Writer, _ io.Writer) error {
+					return c.script
+				},
+			}
+			h.Execute()
+			for _, p := range c.expected {
+				if text, ok := p["text"].(string); ok {
+					p["text"] = strings.Replace(text, "<dir>", tempDir, 1)
+				}
+			}
+			require.EqualValues(t, c.expected, rec.payloads)
+			for _, header := range rec.headers {
+				require.Equal(t, "ichigeki", header.Get("X-Test"))
+				require.Equal(t, "application/json", header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+	webhook := &notify.Webhook{URL: server.URL}
+	err := webhook.Notify(context.Background(), &notify.Event{Type: notify.EventStart, Name: "test_run"})
+	require.EqualError(t, err, "webhook responded 403 Forbidden: invalid_token")
+}
@@ -0,0 +1,154 @@
+package ichigeki
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultRedactMask replaces the secrets in the execution log.
+const DefaultRedactMask = "[REDACTED]"
+
+// Redactor masks secrets in the stored execution log: the output of the script, the args in the header and the error in the footer.
+// The console output is not redacted.
+// The output is redacted line by line, so a secret written in pieces is masked, but a secret across lines is not.
+// A line longer than 64KiB is written in pieces, but never in the middle of a value.
+type Redactor struct {
+	// Env is the patterns of environment variable names whose values are masked, e.g. `*_PASSWORD`. The syntax is path.Match.
+	Env []string
+	// Values are masked as is.
+	Values []string
+	// Patterns are masked where they match.
+	Patterns []*regexp.Regexp
+	// Mask replaces the secrets. If empty, DefaultRedactMask is used.
+	Mask string
+
+	re   *regexp.Regexp
+	mask []byte
+	// longest is the length of the longest value.
+	longest int
+}
+
+// compile builds the matcher from the environment variables at the start of the execution.
+func (r *Redactor) compile() error {
+	for _, pattern := range r.Env {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("env pattern `%s`: %w", pattern, err)
+		}
+	}
+	values := make([]string, 0, len(r.Values))
+	values = append(values, r.Values...)
+	for _, env := range os.Environ() {
+		i := strings.IndexByte(env, '=')
+		if i <= 0 {
+			continue
+		}
+		for _, pattern := range r.Env {
+			if ok, _ := path.Match(pattern, env[:i]); ok {
+				values = append(values, env[i+1:])
+				break
+			}
+		}
+	}
+	r.longest = 0
+	alternatives := make([]string, 0, len(values)+len(r.Patterns))
+	for _, value := range values {
+		// each line of a multi-line value, e.g. a private key.
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				alternatives = append(alternatives, regexp.QuoteMeta(line))
+				if len(line) > r.longest {
+					r.longest = len(line)
+				}
+			}
+		}
+	}
+	// the longest value first, not to leave a part of it when a shorter value is the prefix.
+	sort.SliceStable(alternatives, func(i, j int) bool {
+		return len(alternatives[i]) > len(alternatives[j])
+	})
+	for _, p := range r.Patterns {
+		alternatives = append(alternatives, "(?:"+p.String()+")")
+	}
+	r.re = nil
+	if len(alternatives) > 0 {
+		re, err := regexp.Compile(strings.Join(alternatives, "|"))
+		if err != nil {
+			return err
+		}
+		r.re = re
+	}
+	r.mask = []byte(r.Mask)
+	if r.Mask == "" {
+		r.mask = []byte(DefaultRedactMask)
+	}
+	return nil
+}
+
+func (r *Redactor) redact(b []byte) []byte {
+	if r == nil || r.re == nil {
+		return b
+	}
+	return r.re.ReplaceAllLiteral(b, r.mask)
+}
+
+// splitPoint returns the length of the head of the partial line b to write before the rest of the line.
+// The rest keeps the longest value - 1 bytes, which can be the beginning of a value completed by the next write,
+// and a value across the split is left in the head. A match of Patterns can still be split.
+func (r *Redactor) splitPoint(b []byte) int {
+	if r == nil || r.re == nil || r.longest <= 1 {
+		return len(b)
+	}
+	n := len(b) - (r.longest - 1)
+	if n <= 0 {
+		return len(b)
+	}
+	for _, loc := range r.re.FindAllIndex(b, -1) {
+		if loc[0] < n && n < loc[1] {
+			n = loc[1]
+		}
+	}
+	return n
+}
+
+func (r *Redactor) redactString(str string) string {
+	if r == nil || r.re == nil {
+		return str
+	}
+	return string(r.redact([]byte(str)))
+}
+
+// redactError returns the error with the redacted message. errors.Is and errors.As see through it.
+func (r *Redactor) redactError(err error) error {
+	if r == nil || r.re == nil || err == nil {
+		return err
+	}
+	return &redactedError{msg: r.redactString(err.Error()), err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+func (r *Redactor) redactStrings(strs []string) []string {
+	if r == nil || r.re == nil {
+		return strs
+	}
+	redacted := make([]string, len(strs))
+	for i, str := range strs {
+		redacted[i] = r.redactString(str)
+	}
+	return redacted
+}
@@ -0,0 +1,125 @@
+package ichigeki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+// Outcome is the recorded result of an execution, written in the log footer.
+type Outcome = logparse.Outcome
+
+const (
+	OutcomeSuccess     = logparse.OutcomeSuccess
+	OutcomeFailure     = logparse.OutcomeFailure
+	OutcomeInterrupted = logparse.OutcomeInterrupted
+	OutcomeTimeout     = logparse.OutcomeTimeout
+)
+
+// RerunPolicy decides whether a name that already has an execution log may be executed again.
+type RerunPolicy int
+
+const (
+	// RerunNever refuses to execute when any execution log exists. (default)
+	RerunNever RerunPolicy = iota
+	// RerunAllowAfterFailure allows rerun when the previous execution failed, timed out or was interrupted.
+	RerunAllowAfterFailure
+	// RerunAllowAfterInterrupted allows rerun only when the previous execution was interrupted.
+	RerunAllowAfterInterrupted
+)
+
+func ParseRerunPolicy(str string) (RerunPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "never":
+		return RerunNever, nil
+	case "allow-after-failure":
+		return RerunAllowAfterFailure, nil
+	case "allow-after-interrupted":
+		return RerunAllowAfterInterrupted, nil
+	}
+	return RerunNever, fmt.Errorf("unknown rerun policy `%s`", str)
+}
+
+func (p RerunPolicy) String() string {
+	switch p {
+	case RerunNever:
+		return "never"
+	case RerunAllowAfterFailure:
+		return "allow-after-failure"
+	case RerunAllowAfterInterrupted:
+		return "allow-after-interrupted"
+	}
+	return fmt.Sprintf("RerunPolicy(%d)", int(p))
+}
+
+// Allows reports whether rerun is allowed after the previous execution ended with the outcome.
+func (p RerunPolicy) Allows(outcome Outcome) bool {
+	switch p {
+	case RerunAllowAfterFailure:
+		return outcome == OutcomeFailure || outcome == OutcomeTimeout || outcome == OutcomeInterrupted
+	case RerunAllowAfterInterrupted:
+		return outcome == OutcomeInterrupted
+	}
+	return false
+}
+
+// Reader is an optional interface of LogDestination.
+// NewReader opens the stored execution log.
+type Reader interface {
+	NewReader(ctx context.Context) (io.ReadCloser, error)
+}
+
+// MetadataReader is an optional interface of LogDestination.
+// ReadMetadata reads the header and the footer of the stored execution log without the body, e.g. by the ranged requests,
+// so that listing the execution logs does not read all of them in full.
+type MetadataReader interface {
+	ReadMetadata(ctx context.Context) (*logparse.Log, error)
+}
+
+// ReadMetadata reads the header and the footer of the stored execution log,
+// by ReadMetadata if the log destination is a MetadataReader, otherwise by reading the whole log.
+func ReadMetadata(ctx context.Context, ld LogDestination) (*logparse.Log, error) {
+	if mr, ok := ld.(MetadataReader); ok {
+		return mr.ReadMetadata(ctx)
+	}
+	reader, ok := ld.(Reader)
+	if !ok {
+		return nil, errors.New("log destination is not readable")
+	}
+	r, err := reader.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return logparse.ParseMetadata(r)
+}
+
+// ErrLogChanged is returned by Archiver.Archive when the stored execution log is not the one read before, e.g. another execution has rerun the name in the meantime.
+var ErrLogChanged = errors.New("execution log changed after it was read")
+
+// Archiver is an optional interface of LogDestination.
+// Archive moves the stored execution log aside, under the name with the suffix appended, so that the name can be executed again.
+// verify checks that the moved log is still the one read before; if it fails, the log is left in place and Archive returns the error.
+// A log destination may check it by other means instead, e.g. the ETag of the log read before.
+// If no execution log is stored, Archive does nothing.
+type Archiver interface {
+	Archive(ctx context.Context, suffix string, verify func(r io.Reader) error) error
+}
+
+// verifyPrevious returns the verify of Archiver.Archive, which accepts the log of the same execution in the same state as previous.
+func verifyPrevious(previous *logparse.Log) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		l, err := logparse.ParseMetadata(r)
+		if err != nil {
+			return err
+		}
+		if l.RunID != previous.RunID || l.Truncated != previous.Truncated {
+			return ErrLogChanged
+		}
+		return nil
+	}
+}
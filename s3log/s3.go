@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -14,17 +16,107 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/mashiike/ichigeki"
+	"github.com/mashiike/ichigeki/logparse"
 )
 
 type S3Client interface {
 	s3.HeadObjectAPIClient
 	manager.UploadAPIClient
+	manager.DownloadAPIClient
+	s3.ListObjectsV2APIClient
+	CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	PutObjectTagging(context.Context, *s3.PutObjectTaggingInput, ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+	GetObjectTagging(context.Context, *s3.GetObjectTaggingInput, ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
 }
+
+var (
+	_ ichigeki.Claimer        = (*LogDestination)(nil)
+	_ ichigeki.Releaser       = (*LogDestination)(nil)
+	_ ichigeki.Reader         = (*LogDestination)(nil)
+	_ ichigeki.MetadataReader = (*LogDestination)(nil)
+	_ ichigeki.Archiver       = (*LogDestination)(nil)
+	_ ichigeki.Lister         = (*LogDestination)(nil)
+	_ ichigeki.MetadataSetter = (*LogDestination)(nil)
+)
+
+// The tags added to the object automatically.
+const (
+	TagName     = "ichigeki:name"
+	TagExecDate = "ichigeki:exec-date"
+	TagOutcome  = "ichigeki:outcome"
+)
+
+const (
+	// maxTags is the limit of the tags of an S3 object.
+	maxTags = 10
+	// autoTags is the number of the automatic tags.
+	autoTags = 3
+)
+
 type Config struct {
 	Bucket        string
 	ObjectPrefix  string
 	ObjectPostfix string
+	// Compression compresses the object, e.g. CompressionGzip puts `name.log.gz` with `Content-Encoding: gzip`.
+	Compression ichigeki.Compression
+	// ServerSideEncryption is `AES256` or `aws:kms`. If empty, the default encryption of the bucket is used.
+	ServerSideEncryption types.ServerSideEncryption
+	// SSEKMSKeyID is the KMS key of `aws:kms`. If empty, the AWS managed key is used.
+	SSEKMSKeyID  string
+	StorageClass types.StorageClass
+	// Tags are added to the object, in addition to TagName, TagExecDate and TagOutcome.
+	Tags map[string]string
+	// Metadata is the user-defined metadata of the object (`x-amz-meta-*`).
+	Metadata map[string]string
+	// ExpectedBucketOwner is the account ID of the bucket owner. The requests fail if the bucket is owned by another account.
+	ExpectedBucketOwner string
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	if cfg.ServerSideEncryption != "" && !validServerSideEncryption(cfg.ServerSideEncryption) {
+		return fmt.Errorf("unknown server side encryption `%s`", cfg.ServerSideEncryption)
+	}
+	if cfg.SSEKMSKeyID != "" && cfg.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("SSE KMS key ID requires server side encryption `%s`", types.ServerSideEncryptionAwsKms)
+	}
+	if cfg.StorageClass != "" && !validStorageClass(cfg.StorageClass) {
+		return fmt.Errorf("unknown storage class `%s`", cfg.StorageClass)
+	}
+	if len(cfg.Tags) > maxTags-autoTags {
+		return fmt.Errorf("too many tags: up to %d tags can be added, because %d tags are added automatically", maxTags-autoTags, autoTags)
+	}
+	for key := range cfg.Tags {
+		if strings.HasPrefix(key, "ichigeki:") || strings.HasPrefix(key, "aws:") {
+			return fmt.Errorf("tag `%s` is reserved", key)
+		}
+	}
+	return nil
+}
+
+func validServerSideEncryption(sse types.ServerSideEncryption) bool {
+	for _, v := range sse.Values() {
+		if v == sse {
+			return true
+		}
+	}
+	return false
+}
+
+func validStorageClass(sc types.StorageClass) bool {
+	for _, v := range sc.Values() {
+		if v == sc {
+			return true
+		}
+	}
+	return false
 }
 
 type LogDestination struct {
@@ -32,6 +124,11 @@ type LogDestination struct {
 	cfg    *Config
 	client S3Client
 	w      *s3Writer
+	meta   *ichigeki.Metadata
+	// claimed is true while the empty object put by Claim is not overwritten yet.
+	claimed bool
+	// etags are the ETags of the objects read, so that Archive moves only the object read before.
+	etags map[string]string
 }
 
 func New(ctx context.Context, cfg *Config) (*LogDestination, error) {
@@ -39,53 +136,532 @@ func New(ctx context.Context, cfg *Config) (*LogDestination, error) {
 	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
 		opts = append(opts, config.WithRegion(region))
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
+	return NewWithClient(s3.NewFromConfig(awsCfg), cfg)
+}
+
+// NewWithClient returns the LogDestination with the client, instead of the client of the default AWS config.
+func NewWithClient(client S3Client, cfg *Config) (*LogDestination, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	ld := &LogDestination{
 		cfg:    cfg,
-		client: s3.NewFromConfig(awsCfg),
+		client: client,
 	}
 	return ld, nil
 }
 
 func (ld LogDestination) object() string {
+	return ld.objectWithSuffix("", ld.cfg.Compression)
+}
+
+func (ld LogDestination) objectWithSuffix(suffix string, c ichigeki.Compression) string {
+	key := fmt.Sprintf("%s%s%s%s%s", ld.cfg.ObjectPrefix, ld.name, suffix, ld.objectPostfix(), c.Extension())
+	return strings.TrimLeft(key, "/")
+}
+
+func (ld LogDestination) contentEncoding() *string {
+	if enc := ld.cfg.Compression.ContentEncoding(); enc != "" {
+		return aws.String(enc)
+	}
+	return nil
+}
+
+func (ld LogDestination) expectedBucketOwner() *string {
+	if ld.cfg.ExpectedBucketOwner != "" {
+		return aws.String(ld.cfg.ExpectedBucketOwner)
+	}
+	return nil
+}
+
+func (ld LogDestination) sseKMSKeyID() *string {
+	if ld.cfg.SSEKMSKeyID != "" {
+		return aws.String(ld.cfg.SSEKMSKeyID)
+	}
+	return nil
+}
+
+// tags returns the configured tags and the automatic ones known so far.
+func (ld LogDestination) tags() map[string]string {
+	tags := make(map[string]string, len(ld.cfg.Tags)+autoTags)
+	for key, value := range ld.cfg.Tags {
+		tags[key] = value
+	}
+	tags[TagName] = ld.name
+	if ld.meta != nil {
+		if ld.meta.ExecDate != "" {
+			tags[TagExecDate] = ld.meta.ExecDate
+		}
+		if ld.meta.Outcome != "" {
+			tags[TagOutcome] = string(ld.meta.Outcome)
+		}
+	}
+	for key, value := range tags {
+		tags[key] = tagValue(value)
+	}
+	return tags
+}
+
+// tagging returns the tags in the form of the `x-amz-tagging` header.
+func (ld LogDestination) tagging() *string {
+	values := url.Values{}
+	for key, value := range ld.tags() {
+		values.Set(key, value)
+	}
+	return aws.String(values.Encode())
+}
+
+// maxTagValueLength is the limit of the length of a tag value.
+const maxTagValueLength = 256
+
+// tagValue replaces the characters not allowed in a tag value with `_`, because the name can contain any character.
+func tagValue(value string) string {
+	runes := []rune(value)
+	if len(runes) > maxTagValueLength {
+		runes = runes[:maxTagValueLength]
+	}
+	for i, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(" +-=._:/@", r):
+		default:
+			runes[i] = '_'
+		}
+	}
+	return string(runes)
+}
+
+func (ld LogDestination) objectPrefix() string {
+	return strings.TrimLeft(ld.cfg.ObjectPrefix, "/")
+}
+
+func (ld LogDestination) objectPostfix() string {
 	if ld.cfg.ObjectPostfix == "" {
-		ld.cfg.ObjectPostfix = ".log"
+		return ".log"
 	}
-	key := fmt.Sprintf("%s%s%s", ld.cfg.ObjectPrefix, ld.name, ld.cfg.ObjectPostfix)
-	return strings.TrimLeft(key, "/")
+	return ld.cfg.ObjectPostfix
 }
 
+// AlreadyExists checks the object in any compression, so that changing Compression never allows a second execution.
 func (ld *LogDestination) AlreadyExists(ctx context.Context) (bool, error) {
-	_, err := ld.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(ld.cfg.Bucket),
-		Key:    aws.String(ld.object()),
+	for _, c := range ld.cfg.Compression.SearchOrder() {
+		if exists, err := ld.exists(ctx, ld.objectWithSuffix("", c)); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+func (ld *LogDestination) exists(ctx context.Context, key string) (bool, error) {
+	output, err := ld.headObject(ctx, key)
+	return output != nil, err
+}
+
+// headObject returns nil if the object does not exist.
+func (ld *LogDestination) headObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	output, err := ld.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
 	})
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
 			if ae.ErrorCode() == "NotFound" {
-				return false, nil
+				return nil, nil
 			}
 		}
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	return output, nil
+}
 
+// NewReader gets the object in the configured compression, or else in any other, and decompresses it.
+// The object is decompressed by the key, because the SDK does not decode `Content-Encoding: gzip`.
+func (ld *LogDestination) NewReader(ctx context.Context) (io.ReadCloser, error) {
+	var notFound error
+	for _, c := range ld.cfg.Compression.SearchOrder() {
+		key := ld.objectWithSuffix("", c)
+		output, err := ld.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:              aws.String(ld.cfg.Bucket),
+			Key:                 aws.String(key),
+			ExpectedBucketOwner: ld.expectedBucketOwner(),
+		})
+		if err != nil {
+			var ae smithy.APIError
+			if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchKey" {
+				if notFound == nil {
+					notFound = err
+				}
+				continue
+			}
+			return nil, err
+		}
+		ld.readETag(key, output.ETag)
+		return c.NewReader(output.Body)
+	}
+	return nil, notFound
 }
-func (ld *LogDestination) NewWriter(ctx context.Context) (io.Writer, io.Writer, error) {
-	ld.w = newS3Writer(ld.client, &s3.PutObjectInput{
-		Bucket: aws.String(ld.cfg.Bucket),
-		Key:    aws.String(ld.object()),
+
+func (ld *LogDestination) readETag(key string, etag *string) {
+	if ld.etags == nil {
+		ld.etags = make(map[string]string)
+	}
+	ld.etags[key] = aws.ToString(etag)
+}
+
+// ReadMetadata reads the header and the footer of the execution log by the ranged requests, without the body.
+// The compressed object can not be read from the end, so the outcome is read from TagOutcome and the other footer fields are left empty.
+// The compressed object without TagOutcome, e.g. of a crashed execution, is read in full.
+func (ld *LogDestination) ReadMetadata(ctx context.Context) (*logparse.Log, error) {
+	for _, c := range ld.cfg.Compression.SearchOrder() {
+		key := ld.objectWithSuffix("", c)
+		head, err := ld.headObject(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			continue
+		}
+		ld.readETag(key, head.ETag)
+		if c == ichigeki.CompressionNone {
+			return logparse.ParseMetadataAt(&objectReaderAt{ctx: ctx, ld: ld, key: key, etag: head.ETag}, head.ContentLength)
+		}
+		if head.ContentLength > 0 {
+			if l, err := ld.readCompressedMetadata(ctx, key, head.ETag, c); err != nil || l != nil {
+				return l, err
+			}
+		}
+		r, err := ld.newObjectReader(ctx, key, head.ETag, c)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return logparse.ParseMetadata(r)
+	}
+	return nil, fmt.Errorf("s3://%s/%s: %w", ld.cfg.Bucket, ld.object(), os.ErrNotExist)
+}
+
+// readCompressedMetadata reads the header of the compressed object and the outcome of TagOutcome. It returns nil if the object has no TagOutcome.
+func (ld *LogDestination) readCompressedMetadata(ctx context.Context, key string, etag *string, c ichigeki.Compression) (*logparse.Log, error) {
+	output, err := ld.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
 	})
+	if err != nil {
+		return nil, fmt.Errorf("get object tagging: %w", err)
+	}
+	var outcome string
+	for _, tag := range output.TagSet {
+		if aws.ToString(tag.Key) == TagOutcome {
+			outcome = aws.ToString(tag.Value)
+		}
+	}
+	if outcome == "" {
+		return nil, nil
+	}
+	r, err := ld.newObjectReader(ctx, key, etag, c)
+	if err != nil {
+		return nil, err
+	}
+	// the rest of the object is not downloaded, because the body is closed after the header.
+	defer r.Close()
+	l, err := logparse.ParseHeader(r)
+	if err != nil || l.Truncated {
+		return nil, err
+	}
+	l.Outcome = logparse.Outcome(outcome)
+	return l, nil
+}
+
+// newObjectReader gets the object of the ETag, so that the object replaced after the ETag is read fails.
+func (ld *LogDestination) newObjectReader(ctx context.Context, key string, etag *string, c ichigeki.Compression) (io.ReadCloser, error) {
+	output, err := ld.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Key:                 aws.String(key),
+		IfMatch:             etag,
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.NewReader(output.Body)
+}
+
+// objectReaderAt reads the object of the ETag by the ranged requests.
+type objectReaderAt struct {
+	ctx  context.Context
+	ld   *LogDestination
+	key  string
+	etag *string
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	output, err := r.ld.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(r.ld.cfg.Bucket),
+		Key:                 aws.String(r.key),
+		Range:               aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+		IfMatch:             r.etag,
+		ExpectedBucketOwner: r.ld.expectedBucketOwner(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer output.Body.Close()
+	n, err := io.ReadFull(output.Body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Archive copies the execution log object to the key with the suffix, and deletes the original.
+// Both are conditional on the ETag of the object read before, or else of the object verified by verify,
+// so that the object rewritten by another execution in the meantime is never archived.
+func (ld *LogDestination) Archive(ctx context.Context, suffix string, verify func(r io.Reader) error) error {
+	for _, c := range ld.cfg.Compression.SearchOrder() {
+		original := ld.objectWithSuffix("", c)
+		head, err := ld.headObject(ctx, original)
+		if err != nil {
+			return err
+		}
+		if head == nil {
+			continue
+		}
+		etag, ok := ld.etags[original]
+		if !ok {
+			etag = aws.ToString(head.ETag)
+			r, err := ld.newObjectReader(ctx, original, head.ETag, c)
+			if err != nil {
+				return ld.archiveError(original, err)
+			}
+			err = verify(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		archived := ld.objectWithSuffix(suffix, c)
+		if exists, err := ld.exists(ctx, archived); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("s3://%s/%s already exists", ld.cfg.Bucket, archived)
+		}
+		_, err = ld.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:                    aws.String(ld.cfg.Bucket),
+			Key:                       aws.String(archived),
+			CopySource:                aws.String((&url.URL{Path: ld.cfg.Bucket + "/" + original}).EscapedPath()),
+			CopySourceIfMatch:         aws.String(etag),
+			ServerSideEncryption:      ld.cfg.ServerSideEncryption,
+			SSEKMSKeyId:               ld.sseKMSKeyID(),
+			StorageClass:              ld.cfg.StorageClass,
+			ExpectedBucketOwner:       ld.expectedBucketOwner(),
+			ExpectedSourceBucketOwner: ld.expectedBucketOwner(),
+		})
+		if err != nil {
+			return ld.archiveError(original, fmt.Errorf("copy object: %w", err))
+		}
+		_, err = ld.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:              aws.String(ld.cfg.Bucket),
+			Key:                 aws.String(original),
+			ExpectedBucketOwner: ld.expectedBucketOwner(),
+		}, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("If-Match", etag))
+		})
+		if err != nil {
+			return ld.archiveError(original, fmt.Errorf("delete object: %w", err))
+		}
+		delete(ld.etags, original)
+	}
+	return nil
+}
+
+// archiveError tags the failed precondition with ichigeki.ErrLogChanged.
+func (ld *LogDestination) archiveError(key string, err error) error {
+	if conditionFailed(err) {
+		return fmt.Errorf("s3://%s/%s: %w", ld.cfg.Bucket, key, ichigeki.ErrLogChanged)
+	}
+	return err
+}
+
+// conditionFailed reports whether the conditional request failed, because the object is changed by another request.
+func conditionFailed(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	return false
+}
+
+// Claim puts an empty object with `If-None-Match: *`, so that only one execution can reserve the name.
+// The object is overwritten by the execution log later.
+// If the object in another compression exists, the claimed object is deleted and the name is not reserved.
+func (ld *LogDestination) Claim(ctx context.Context) error {
+	input := ld.putObjectInput()
+	input.Body = strings.NewReader("")
+	_, err := ld.client.PutObject(ctx, input, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("If-None-Match", "*"))
+	})
+	if err != nil {
+		if conditionFailed(err) {
+			return fmt.Errorf("%s: %w", ld.String(), ichigeki.ErrAlreadyClaimed)
+		}
+		return err
+	}
+	for _, c := range ld.cfg.Compression.SearchOrder()[1:] {
+		other := ld.objectWithSuffix("", c)
+		exists, err := ld.exists(ctx, other)
+		if err == nil && !exists {
+			continue
+		}
+		if derr := ld.deleteObject(ctx); derr != nil {
+			log.Printf("[warn] delete claimed object failed: %s", derr.Error())
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("s3://%s/%s: %w", ld.cfg.Bucket, other, ichigeki.ErrAlreadyClaimed)
+	}
+	ld.claimed = true
+	return nil
+}
+
+// Release deletes the empty object put by Claim, if it is not overwritten by the execution log yet.
+func (ld *LogDestination) Release(ctx context.Context) error {
+	if !ld.claimed {
+		return nil
+	}
+	ld.claimed = false
+	return ld.deleteObject(ctx)
+}
+
+func (ld *LogDestination) deleteObject(ctx context.Context) error {
+	_, err := ld.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Key:                 aws.String(ld.object()),
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
+	})
+	return err
+}
+
+// List enumerates the objects under ObjectPrefix with ObjectPostfix, compressed or not.
+func (ld *LogDestination) List(ctx context.Context, namePrefix string) ([]ichigeki.LogEntry, error) {
+	prefix := ld.objectPrefix()
+	postfix := ld.objectPostfix()
+	p := s3.NewListObjectsV2Paginator(ld.client, &s3.ListObjectsV2Input{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Prefix:              aws.String(prefix + namePrefix),
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
+	})
+	logs := make([]ichigeki.LogEntry, 0)
+	index := make(map[string]int)
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range output.Contents {
+			key := aws.ToString(obj.Key)
+			name, ok := "", false
+			for _, c := range ichigeki.Compressions {
+				if strings.HasSuffix(key, postfix+c.Extension()) {
+					name, ok = strings.TrimSuffix(strings.TrimPrefix(key, prefix), postfix+c.Extension()), true
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+			lastModified := aws.ToTime(obj.LastModified)
+			if i, ok := index[name]; ok {
+				if lastModified.After(logs[i].LastModified) {
+					logs[i].LastModified = lastModified
+				}
+				continue
+			}
+			index[name] = len(logs)
+			logs = append(logs, ichigeki.LogEntry{
+				Name:         name,
+				LastModified: lastModified,
+			})
+		}
+	}
+	return logs, nil
+}
+
+// putObjectInput returns the input to put the execution log object, without the body.
+func (ld *LogDestination) putObjectInput() *s3.PutObjectInput {
+	var metadata map[string]string
+	if len(ld.cfg.Metadata) > 0 {
+		metadata = make(map[string]string, len(ld.cfg.Metadata))
+		for key, value := range ld.cfg.Metadata {
+			metadata[key] = value
+		}
+	}
+	return &s3.PutObjectInput{
+		Bucket:               aws.String(ld.cfg.Bucket),
+		Key:                  aws.String(ld.object()),
+		ContentEncoding:      ld.contentEncoding(),
+		ServerSideEncryption: ld.cfg.ServerSideEncryption,
+		SSEKMSKeyId:          ld.sseKMSKeyID(),
+		StorageClass:         ld.cfg.StorageClass,
+		Metadata:             metadata,
+		Tagging:              ld.tagging(),
+		ExpectedBucketOwner:  ld.expectedBucketOwner(),
+	}
+}
+
+// SetMetadata keeps the metadata of the execution, to tag the object with the exec date and the outcome.
+func (ld *LogDestination) SetMetadata(m *ichigeki.Metadata) {
+	ld.meta = m
+}
+
+func (ld *LogDestination) NewWriter(ctx context.Context) (io.Writer, io.Writer, error) {
+	ld.claimed = false
+	ld.w = newS3Writer(ld.client, ld.putObjectInput(), ld.cfg.Compression)
 	return ld.w, ld.w, nil
 }
 
+// Cleanup finishes the upload, and then tags the object with the outcome, which is known only after the execution.
 func (ld *LogDestination) Cleanup(_ context.Context) {
-	if ld.w != nil {
-		ld.w.Close()
+	if ld.w == nil {
+		return
+	}
+	ld.w.Close()
+	ld.w = nil
+	if ld.meta == nil || ld.meta.Outcome == "" {
+		return
+	}
+	tags := ld.tags()
+	tagSet := make([]types.Tag, 0, len(tags))
+	for key, value := range tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	sort.Slice(tagSet, func(i, j int) bool {
+		return aws.ToString(tagSet[i].Key) < aws.ToString(tagSet[j].Key)
+	})
+	_, err := ld.client.PutObjectTagging(context.Background(), &s3.PutObjectTaggingInput{
+		Bucket:              aws.String(ld.cfg.Bucket),
+		Key:                 aws.String(ld.object()),
+		Tagging:             &types.Tagging{TagSet: tagSet},
+		ExpectedBucketOwner: ld.expectedBucketOwner(),
+	})
+	if err != nil {
+		log.Printf("[warn] put object tagging failed: %s", err.Error())
 	}
 }
 
@@ -97,42 +673,61 @@ func (ld *LogDestination) String() string {
 	return fmt.Sprintf("s3://%s/%s", ld.cfg.Bucket, ld.object())
 }
 
+// s3Writer uploads the written bytes. It is safe for concurrent use, because both stdout and stderr of the script are written to it.
 type s3Writer struct {
-	w   *io.PipeWriter
-	err error
-	wg  sync.WaitGroup
+	w     *io.PipeWriter
+	zw    io.WriteCloser
+	mu    sync.Mutex
+	errMu sync.Mutex
+	err   error
+	wg    sync.WaitGroup
 }
 
-func newS3Writer(client manager.UploadAPIClient, input *s3.PutObjectInput) *s3Writer {
+func newS3Writer(client manager.UploadAPIClient, input *s3.PutObjectInput, c ichigeki.Compression) *s3Writer {
 	uploader := manager.NewUploader(client)
 	pr, pw := io.Pipe()
 	w := &s3Writer{
-		w: pw,
+		w:  pw,
+		zw: c.NewWriter(pw),
 	}
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
 		input.Body = pr
-		_, w.err = uploader.Upload(context.Background(), input)
+		_, err := uploader.Upload(context.Background(), input)
+		w.errMu.Lock()
+		w.err = err
+		w.errMu.Unlock()
 		pr.Close()
 	}()
 	return w
 }
 
 func (w *s3Writer) Write(p []byte) (int, error) {
-	if w.err != nil {
-		err := w.err
-		w.err = nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errMu.Lock()
+	err := w.err
+	w.err = nil
+	w.errMu.Unlock()
+	if err != nil {
 		return 0, err
 	}
-	return w.w.Write(p)
+	return w.zw.Write(p)
 }
 
 func (w *s3Writer) Close() {
+	w.mu.Lock()
+	if err := w.zw.Close(); err != nil {
+		log.Printf("[error] compressor close failed: %s", err.Error())
+	}
+	w.mu.Unlock()
 	if err := w.w.Close(); err != nil {
 		log.Printf("[error] pipe writer close failed: %s", err.Error())
 	}
 	w.wg.Wait()
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
 	if w.err != nil {
 		log.Printf("[error] upload finish failed: %s", w.err.Error())
 	}
@@ -0,0 +1,542 @@
+package s3log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/mashiike/ichigeki"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	body []byte
+	etag string
+	tags map[string]string
+}
+
+// fakeS3 is an in-memory bucket, with the conditional requests and the ranged reads.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	version int
+	// conflictCode is the error code of the failed `If-None-Match: *`.
+	conflictCode string
+	gets         []*s3.GetObjectInput
+}
+
+var _ S3Client = (*fakeS3)(nil)
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:      make(map[string]*fakeObject),
+		conflictCode: "PreconditionFailed",
+	}
+}
+
+func apiError(code string) error {
+	return &smithy.GenericAPIError{Code: code, Message: code}
+}
+
+// requestHeader returns the headers added by the APIOptions, e.g. smithyhttp.AddHeaderValue.
+func requestHeader(optFns []func(*s3.Options)) (http.Header, error) {
+	var o s3.Options
+	for _, fn := range optFns {
+		fn(&o)
+	}
+	stack := middleware.NewStack("fake", smithyhttp.NewStackRequest)
+	for _, fn := range o.APIOptions {
+		if err := fn(stack); err != nil {
+			return nil, err
+		}
+	}
+	var header http.Header
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(_ context.Context, input interface{}) (interface{}, middleware.Metadata, error) {
+		header = input.(*smithyhttp.Request).Header
+		return nil, middleware.Metadata{}, nil
+	}), stack)
+	if _, _, err := handler.Handle(context.Background(), nil); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// put stores the object with a new ETag. It must be called with mu locked.
+func (c *fakeS3) put(key string, body []byte, tags map[string]string) {
+	c.version++
+	c.objects[key] = &fakeObject{
+		body: body,
+		etag: fmt.Sprintf(`"%d"`, c.version),
+		tags: tags,
+	}
+}
+
+func (c *fakeS3) Put(key string, body string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, []byte(body), nil)
+}
+
+func (c *fakeS3) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[key]
+	if !ok {
+		return "", false
+	}
+	return string(obj.body), true
+}
+
+func (c *fakeS3) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.objects))
+	for key := range c.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *fakeS3) HeadObject(_ context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, apiError("NotFound")
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: int64(len(obj.body)),
+		ETag:          aws.String(obj.etag),
+	}, nil
+}
+
+func (c *fakeS3) GetObject(_ context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets = append(c.gets, input)
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, apiError("NoSuchKey")
+	}
+	if input.IfMatch != nil && aws.ToString(input.IfMatch) != obj.etag {
+		return nil, apiError("PreconditionFailed")
+	}
+	body := obj.body
+	if input.Range != nil {
+		var start, end int
+		if _, err := fmt.Sscanf(aws.ToString(input.Range), "bytes=%d-%d", &start, &end); err != nil {
+			return nil, err
+		}
+		if start >= len(body) {
+			return nil, apiError("InvalidRange")
+		}
+		if end >= len(body) {
+			end = len(body) - 1
+		}
+		body = body[start : end+1]
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		ETag:          aws.String(obj.etag),
+	}, nil
+}
+
+func (c *fakeS3) PutObject(_ context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	header, err := requestHeader(optFns)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(aws.ToString(input.Tagging))
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(values))
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := aws.ToString(input.Key)
+	if _, ok := c.objects[key]; ok && header.Get("If-None-Match") == "*" {
+		return nil, apiError(c.conflictCode)
+	}
+	c.put(key, body, tags)
+	return &s3.PutObjectOutput{ETag: aws.String(c.objects[key].etag)}, nil
+}
+
+func (c *fakeS3) CopyObject(_ context.Context, input *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(aws.ToString(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[strings.TrimPrefix(source, aws.ToString(input.Bucket)+"/")]
+	if !ok {
+		return nil, apiError("NoSuchKey")
+	}
+	if input.CopySourceIfMatch != nil && aws.ToString(input.CopySourceIfMatch) != obj.etag {
+		return nil, apiError("PreconditionFailed")
+	}
+	c.put(aws.ToString(input.Key), obj.body, obj.tags)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (c *fakeS3) DeleteObject(_ context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	header, err := requestHeader(optFns)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := aws.ToString(input.Key)
+	if obj, ok := c.objects[key]; ok {
+		if etag := header.Get("If-Match"); etag != "" && etag != obj.etag {
+			return nil, apiError("PreconditionFailed")
+		}
+	}
+	delete(c.objects, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (c *fakeS3) PutObjectTagging(_ context.Context, input *s3.PutObjectTaggingInput, _ ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, apiError("NoSuchKey")
+	}
+	obj.tags = make(map[string]string, len(input.Tagging.TagSet))
+	for _, tag := range input.Tagging.TagSet {
+		obj.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &s3.PutObjectTaggingOutput{}, nil
+}
+
+func (c *fakeS3) GetObjectTagging(_ context.Context, input *s3.GetObjectTaggingInput, _ ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, apiError("NoSuchKey")
+	}
+	tagSet := make([]types.Tag, 0, len(obj.tags))
+	for key, value := range obj.tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return &s3.GetObjectTaggingOutput{TagSet: tagSet}, nil
+}
+
+func (c *fakeS3) ListObjectsV2(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	contents := make([]types.Object, 0)
+	for _, key := range c.Keys() {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			contents = append(contents, types.Object{Key: aws.String(key), LastModified: aws.Time(time.Now())})
+		}
+	}
+	return &s3.ListObjectsV2Output{Contents: contents}, nil
+}
+
+func (c *fakeS3) CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return nil, errors.New("multipart upload is not supported")
+}
+
+func (c *fakeS3) UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return nil, errors.New("multipart upload is not supported")
+}
+
+func (c *fakeS3) CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return nil, errors.New("multipart upload is not supported")
+}
+
+func (c *fakeS3) AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return nil, errors.New("multipart upload is not supported")
+}
+
+func newTestLogDestination(t *testing.T, client S3Client, c ichigeki.Compression) *LogDestination {
+	t.Helper()
+	ld, err := NewWithClient(client, &Config{
+		Bucket:       "ichigeki-test",
+		ObjectPrefix: "logs/",
+		Compression:  c,
+	})
+	require.NoError(t, err)
+	return ld
+}
+
+// execute runs the script writing the body to a new LogDestination.
+func execute(t *testing.T, client S3Client, c ichigeki.Compression, body string) {
+	t.Helper()
+	h := &ichigeki.Hissatsu{
+		Name:           "migration",
+		LogDestination: newTestLogDestination(t, client, c),
+		ConfirmDialog:  ichigeki.Bool(false),
+		Script: func(_ ichigeki.Context, stdout io.Writer, _ io.Writer) error {
+			_, err := io.WriteString(stdout, body)
+			return err
+		},
+	}
+	require.NoError(t, h.Execute())
+}
+
+func TestClaim(t *testing.T) {
+	for _, code := range []string{"PreconditionFailed", "ConditionalRequestConflict"} {
+		t.Run(code, func(t *testing.T) {
+			client := newFakeS3()
+			client.conflictCode = code
+			ctx := context.Background()
+			first := newTestLogDestination(t, client, ichigeki.CompressionNone)
+			first.SetName("migration")
+			require.NoError(t, first.Claim(ctx))
+			require.Equal(t, []string{"logs/migration.log"}, client.Keys())
+
+			second := newTestLogDestination(t, client, ichigeki.CompressionNone)
+			second.SetName("migration")
+			err := second.Claim(ctx)
+			require.ErrorIs(t, err, ichigeki.ErrAlreadyClaimed)
+			require.EqualError(t, err, "s3://ichigeki-test/logs/migration.log: already claimed by another execution")
+
+			require.NoError(t, first.Release(ctx))
+			require.Empty(t, client.Keys())
+			require.NoError(t, second.Claim(ctx))
+		})
+	}
+}
+
+func TestClaimOtherCompression(t *testing.T) {
+	client := newFakeS3()
+	client.Put("logs/migration.log.gz", "")
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	ld.SetName("migration")
+	err := ld.Claim(context.Background())
+	require.ErrorIs(t, err, ichigeki.ErrAlreadyClaimed)
+	require.EqualError(t, err, "s3://ichigeki-test/logs/migration.log.gz: already claimed by another execution")
+	// the object claimed in the configured compression is deleted.
+	require.Equal(t, []string{"logs/migration.log.gz"}, client.Keys())
+}
+
+func TestReadMetadata(t *testing.T) {
+	client := newFakeS3()
+	execute(t, client, ichigeki.CompressionNone, strings.Repeat("migrating a row\n", 20000))
+	body, ok := client.Get("logs/migration.log")
+	require.True(t, ok)
+
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	ld.SetName("migration")
+	client.gets = nil
+	l, err := ld.ReadMetadata(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, ichigeki.OutcomeSuccess, l.Outcome)
+	require.Equal(t, "migration", l.Name)
+	require.False(t, l.End.IsZero())
+	require.NotEmpty(t, client.gets)
+	var read int
+	for _, input := range client.gets {
+		require.NotNil(t, input.Range, "the object is read by the ranged requests")
+		require.NotNil(t, input.IfMatch)
+		var start, end int
+		_, err := fmt.Sscanf(aws.ToString(input.Range), "bytes=%d-%d", &start, &end)
+		require.NoError(t, err)
+		read += end - start + 1
+	}
+	require.Less(t, read, len(body)/2, "only the header and the footer are read")
+}
+
+func TestReadMetadataCompressed(t *testing.T) {
+	client := newFakeS3()
+	execute(t, client, ichigeki.CompressionGzip, "migrated\n")
+	ld := newTestLogDestination(t, client, ichigeki.CompressionGzip)
+	ld.SetName("migration")
+
+	// the outcome is read from the tag, without the footer.
+	l, err := ld.ReadMetadata(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, ichigeki.OutcomeSuccess, l.Outcome)
+	require.Equal(t, "migration", l.Name)
+	require.True(t, l.End.IsZero())
+
+	// without the tag, e.g. of a crashed execution, the object is read in full.
+	client.mu.Lock()
+	delete(client.objects["logs/migration.log.gz"].tags, TagOutcome)
+	client.mu.Unlock()
+	l, err = ld.ReadMetadata(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, ichigeki.OutcomeSuccess, l.Outcome)
+	require.False(t, l.End.IsZero())
+}
+
+func TestArchive(t *testing.T) {
+	client := newFakeS3()
+	execute(t, client, ichigeki.CompressionNone, "migrated\n")
+	body, _ := client.Get("logs/migration.log")
+	ctx := context.Background()
+
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	ld.SetName("migration")
+	_, err := ld.ReadMetadata(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ld.Archive(ctx, ".success-20220602120000", func(io.Reader) error {
+		return errors.New("the object read before is not verified again")
+	}))
+	require.Equal(t, []string{"logs/migration.success-20220602120000.log"}, client.Keys())
+	archived, _ := client.Get("logs/migration.success-20220602120000.log")
+	require.Equal(t, body, archived)
+}
+
+func TestArchiveVerify(t *testing.T) {
+	client := newFakeS3()
+	client.Put("logs/migration.log", "first")
+	ctx := context.Background()
+
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	ld.SetName("migration")
+	err := ld.Archive(ctx, ".failure-20220602120000", func(r io.Reader) error {
+		bs, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "first", string(bs))
+		return ichigeki.ErrLogChanged
+	})
+	require.ErrorIs(t, err, ichigeki.ErrLogChanged)
+	require.Equal(t, []string{"logs/migration.log"}, client.Keys())
+
+	require.NoError(t, ld.Archive(ctx, ".failure-20220602120000", func(io.Reader) error {
+		return nil
+	}))
+	require.Equal(t, []string{"logs/migration.failure-20220602120000.log"}, client.Keys())
+}
+
+func TestArchiveChanged(t *testing.T) {
+	client := newFakeS3()
+	client.Put("logs/migration.log", "first")
+	ctx := context.Background()
+
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	ld.SetName("migration")
+	r, err := ld.NewReader(ctx)
+	require.NoError(t, err)
+	r.Close()
+
+	// another execution reruns after the object is read.
+	client.Put("logs/migration.log", "second")
+	err = ld.Archive(ctx, ".failure-20220602120000", func(io.Reader) error {
+		return nil
+	})
+	require.ErrorIs(t, err, ichigeki.ErrLogChanged)
+	require.Equal(t, []string{"logs/migration.log"}, client.Keys())
+	body, _ := client.Get("logs/migration.log")
+	require.Equal(t, "second", body)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tags := make(map[string]string)
+	for i := 0; i < maxTags-autoTags; i++ {
+		tags["key"+strconv.Itoa(i)] = "value"
+	}
+	cases := []struct {
+		cfg      Config
+		expected string
+	}{
+		{cfg: Config{Bucket: "ichigeki-test", Tags: tags}},
+		{cfg: Config{}, expected: "bucket is required"},
+		{
+			cfg:      Config{Bucket: "ichigeki-test", Tags: map[string]string{"ichigeki:name": "migration"}},
+			expected: "tag `ichigeki:name` is reserved",
+		},
+		{
+			cfg:      Config{Bucket: "ichigeki-test", Tags: map[string]string{"aws:createdBy": "me"}},
+			expected: "tag `aws:createdBy` is reserved",
+		},
+		{
+			cfg:      Config{Bucket: "ichigeki-test", ServerSideEncryption: "rot13"},
+			expected: "unknown server side encryption `rot13`",
+		},
+		{
+			cfg:      Config{Bucket: "ichigeki-test", SSEKMSKeyID: "alias/ichigeki"},
+			expected: "SSE KMS key ID requires server side encryption `aws:kms`",
+		},
+	}
+	for _, c := range cases {
+		err := c.cfg.Validate()
+		if c.expected == "" {
+			require.NoError(t, err)
+		} else {
+			require.EqualError(t, err, c.expected)
+		}
+	}
+
+	tooMany := make(map[string]string)
+	for key, value := range tags {
+		tooMany[key] = value
+	}
+	tooMany["team"] = "data"
+	cfg := Config{Bucket: "ichigeki-test", Tags: tooMany}
+	require.EqualError(t, cfg.Validate(), "too many tags: up to 7 tags can be added, because 3 tags are added automatically")
+	_, err := NewWithClient(newFakeS3(), &cfg)
+	require.Error(t, err)
+}
+
+func TestTagValue(t *testing.T) {
+	cases := map[string]string{
+		"migration":               "migration",
+		"2022-06-02 12:00:00+09":  "2022-06-02 12:00:00+09",
+		"user@example.com/a_b.=c": "user@example.com/a_b.=c",
+		"rm -rf *; echo $HOME":    "rm -rf __ echo _HOME",
+		"移行":                      "__",
+		strings.Repeat("a", 300):  strings.Repeat("a", maxTagValueLength),
+	}
+	for value, expected := range cases {
+		require.Equal(t, expected, tagValue(value), value)
+	}
+}
+
+func TestTags(t *testing.T) {
+	ld := newTestLogDestination(t, newFakeS3(), ichigeki.CompressionNone)
+	ld.cfg.Tags = map[string]string{"team": "data&infra"}
+	ld.SetName("migration#1")
+	ld.SetMetadata(&ichigeki.Metadata{ExecDate: "2022-06-02", Outcome: ichigeki.OutcomeTimeout})
+	require.Equal(t, map[string]string{
+		"team":      "data_infra",
+		TagName:     "migration_1",
+		TagExecDate: "2022-06-02",
+		TagOutcome:  "timeout",
+	}, ld.tags())
+}
+
+func TestList(t *testing.T) {
+	client := newFakeS3()
+	client.Put("logs/migration.log", "")
+	client.Put("logs/migration.failure-20220602120000.log", "")
+	client.Put("logs/backfill.log.gz", "")
+	client.Put("logs/backfill.log", "")
+	client.Put("logs/README.txt", "")
+	ld := newTestLogDestination(t, client, ichigeki.CompressionNone)
+	logs, err := ld.List(context.Background(), "")
+	require.NoError(t, err)
+	names := make([]string, 0, len(logs))
+	for _, l := range logs {
+		names = append(names, l.Name)
+	}
+	require.Equal(t, []string{"backfill", "migration.failure-20220602120000", "migration"}, names)
+}
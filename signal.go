@@ -0,0 +1,43 @@
+package ichigeki
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// InterruptedError is returned by the script when the execution is interrupted by a signal.
+// The signal is recorded in the footer of the execution log as `interrupted`, so that it is clear the execution was cut off rather than failed.
+type InterruptedError struct {
+	Signal os.Signal
+	Err    error
+}
+
+func (e *InterruptedError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("interrupted by %s", signalName(e.Signal))
+	}
+	return fmt.Sprintf("interrupted by %s: %s", signalName(e.Signal), e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+func signalName(sig os.Signal) string {
+	switch sig {
+	case nil:
+		return "unknown signal"
+	case os.Interrupt:
+		return "SIGINT"
+	case os.Kill:
+		return "SIGKILL"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGQUIT:
+		return "SIGQUIT"
+	}
+	return sig.String()
+}
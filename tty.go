@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package ichigeki
+
+import "os"
+
+// openTTY opens the controlling terminal for the confirm dialog.
+func openTTY() (in *os.File, out *os.File, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package ichigeki
+
+import "os"
+
+// openTTY opens the console for the confirm dialog.
+func openTTY() (in *os.File, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
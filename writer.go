@@ -0,0 +1,56 @@
+package ichigeki
+
+import (
+	"io"
+	"sync"
+
+	"github.com/mashiike/ichigeki/logparse"
+)
+
+// syncWriter serializes the writes to w, because the script writes stdout and stderr concurrently, e.g. from the copying goroutines of os/exec.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func newSyncWriter(mu *sync.Mutex, w io.Writer) *syncWriter {
+	return &syncWriter{
+		mu: mu,
+		w:  w,
+	}
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// digestWriter digests everything written to the log, in the order it is written.
+type digestWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	digest *logparse.Digest
+}
+
+func newDigestWriter(w io.Writer, key []byte) *digestWriter {
+	return &digestWriter{
+		w:      w,
+		digest: logparse.NewDigest(key),
+	}
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.w.Write(p)
+	w.digest.Write(p[:n])
+	return n, err
+}
+
+// Sum returns the digest of the bytes written so far, e.g. `sha256:<hex>`.
+func (w *digestWriter) Sum() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.digest.String()
+}